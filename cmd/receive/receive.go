@@ -1,40 +1,162 @@
 package receive
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/duyunis/pdh/common"
-	"github.com/duyunis/pdh/options"
-	"github.com/duyunis/pdh/receiver"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/event"
 	"github.com/duyunis/pdh/tools"
+	"github.com/duyunis/pdh/transfer"
 	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
-var opt = &options.ReceiverOptions{}
+var (
+	opt     = &transfer.ReceiveOptions{}
+	maxSize string
+)
 
 var Cmd = &cobra.Command{
 	Use:   "receive",
 	Short: "Receive file(s), or folder (see options with pdh receive -h)",
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) < 1 {
-			choice := tools.GetInput("Enter Share Code: ")
-			if choice != "" {
-				opt.ShareCode = choice
+		if opt.Output != "text" && opt.Output != "json" {
+			tools.Println(tools.Red, fmt.Sprintf("unsupported output: %s", opt.Output))
+			os.Exit(1)
+		}
+		if maxSize != "" {
+			size, err := tools.ParseByteCount(maxSize)
+			if err != nil {
+				tools.Println(tools.Red, fmt.Sprintf("invalid --max-size: %s", err))
+				os.Exit(1)
 			}
+			opt.MaxSize = size
+		}
+		if len(args) > 1 && args[1] == "-" {
+			// write to stdout
+			opt.OutPath = args[1]
+		}
+		sink := transfer.ToDir(opt.OutPath)
+		if opt.OutPath == "-" {
+			// keep stdout for the file data, everything printed goes to stderr
+			sink = transfer.ToWriter(os.Stdout)
+			os.Stdout = os.Stderr
+		}
+		var jsonLines *event.JSONLines
+		if opt.Output == "json" {
+			// no prompts, every transfer is accepted and existing files are kept
+			jsonLines = event.NewJSONLines(os.Stdout)
+			opt.Logger = jsonLines
+			opt.Observer = jsonLines
 		} else {
+			opt.Logger = tools.ConsoleLogger{}
+			opt.Observer = event.NewProgressBars("received")
+			opt.Prompter = terminalPrompter{}
+		}
+		exit := func(result *transfer.Result, err error) {
+			status := 0
+			if err != nil {
+				status = 1
+			}
+			if jsonLines != nil {
+				jsonLines.Summary(result, err, status)
+				os.Exit(status)
+			}
+			if result != nil {
+				printResult(result)
+			}
+			if err != nil {
+				tools.Println(tools.Red, fmt.Sprintf("Receive Failed! %s", err))
+			} else if result != nil && result.Text == "" && len(result.Files)+len(result.Skipped)+len(result.Linked) > 0 {
+				fmt.Println("Receive Completed!")
+			}
+			os.Exit(status)
+		}
+
+		if len(args) > 0 {
 			opt.ShareCode = args[0]
+		} else if jsonLines == nil {
+			opt.ShareCode = tools.GetInput("Enter Share Code: ")
 		}
+		opt.ShareCode = crypt.NormalizeCode(opt.ShareCode)
 		if tools.IsEmpty(opt.ShareCode) {
-			fmt.Println("no share code")
-			return
+			exit(nil, errors.New("no share code"))
 		}
-		rec := receiver.NewReceiver(opt)
-		rec.Receive()
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM)
+		defer stop()
+		exit(transfer.Receive(ctx, opt, sink))
 	},
 }
 
+// printResult shows the received text, or what happened to the files
+func printResult(result *transfer.Result) {
+	if result.Text != "" {
+		if opt.OutPath == "" {
+			fmt.Print("\r")
+			fmt.Println(result.Text)
+		}
+		return
+	}
+	if len(result.Skipped) > 0 {
+		tools.Println(tools.Yellow, fmt.Sprintf("%d files skipped", len(result.Skipped)))
+	}
+	if len(result.Verified) > 0 {
+		tools.Println(tools.Green, fmt.Sprintf("%d files verified", len(result.Verified)))
+	}
+	if len(result.Linked) > 0 {
+		tools.Println(tools.Green, fmt.Sprintf("%d symlinks created", len(result.Linked)))
+	}
+	if len(result.Failed) > 0 {
+		tools.Println(tools.Red, fmt.Sprintf("%d files failed:", len(result.Failed)))
+		for _, f := range result.Failed {
+			tools.Println(tools.Red, "  "+f)
+		}
+	}
+}
+
+// terminalPrompter asks the user on stdin
+type terminalPrompter struct{}
+
+func (terminalPrompter) AcceptTransfer(offer *transfer.Offer) bool {
+	if offer.Stream && offer.Size == 0 {
+		fmt.Printf("\rAccept a stream of unknown size? (Y/n)")
+	} else {
+		fmt.Printf("\rAccept %d files and %d folders (%s)? (Y/n)", offer.Files, offer.Folders, tools.ByteCountDecimal(offer.Size))
+	}
+	fmt.Println()
+	return isYes(tools.GetInput(""))
+}
+
+func (terminalPrompter) Overwrite(pathToFile string) bool {
+	fmt.Printf("\rFile %s is existed, do you want to overwrite it? (Y/n)", pathToFile)
+	fmt.Println()
+	return isYes(tools.GetInput(""))
+}
+
+func isYes(choice string) bool {
+	choice = strings.ToLower(choice)
+	return choice == "" || choice == "y" || choice == "yes"
+}
+
 func init() {
 	Cmd.PersistentFlags().StringVarP(&opt.Relay, "relay", "", common.PublicRelay, "relay address")
-	Cmd.PersistentFlags().StringVarP(&opt.OutPath, "out", "o", "", "receive path")
+	Cmd.PersistentFlags().StringVarP(&opt.RelayCA, "relay-ca", "", "", "CA certificate (PEM) to verify the relay with, connects with TLS")
+	Cmd.PersistentFlags().StringVarP(&opt.RelayToken, "relay-token", "", "", "access token of the relay")
+	Cmd.PersistentFlags().BoolVarP(&opt.RelayTLS, "relay-tls", "", false, "connect to the relay with TLS verified by the system roots (default: false)")
+	Cmd.PersistentFlags().StringVarP(&opt.OutPath, "out", "o", "", "receive path, - writes to stdout")
 	Cmd.PersistentFlags().BoolVarP(&opt.LocalNetwork, "local", "", false, "use local network (default: false)")
 	Cmd.PersistentFlags().StringVarP(&opt.LocalPort, "local-port", "", "6880", "effect when the local network is enabled")
+	Cmd.PersistentFlags().BoolVarP(&opt.SkipExisting, "skip-existing", "", false, "skip files that already exist with the same size and content without asking (default: false)")
+	Cmd.PersistentFlags().BoolVarP(&opt.UnsafeSymlinks, "unsafe-symlinks", "", false, "also create symlinks that point outside the receive path, they are refused otherwise (default: false)")
+	Cmd.PersistentFlags().BoolVarP(&opt.Yes, "yes", "y", false, "accept the transfer and overwrite existing files without asking (default: false)")
+	Cmd.PersistentFlags().StringVarP(&opt.OnConflict, "on-conflict", "", "ask", "what to do with an existing file: ask, overwrite, skip, rename, newer or fail")
+	Cmd.PersistentFlags().StringVarP(&maxSize, "max-size", "", "", "refuse transfers larger than this size, like 500MB or 2GB")
+	Cmd.PersistentFlags().StringVarP(&opt.Output, "output", "", "text", "output format, text or json (newline delimited events, no prompts)")
+	Cmd.PersistentFlags().BoolVarP(&opt.Extract, "extract", "", false, "extract received archives (--archive or --zip of the sender) instead of keeping them (default: false)")
+	Cmd.PersistentFlags().BoolVarP(&opt.Quarantine, "quarantine", "", false, "keep files that failed verification as *.corrupt instead of deleting them (default: false)")
 }
@@ -1,27 +1,76 @@
 package relay
 
 import (
+	"github.com/duyunis/pdh/event"
 	"github.com/duyunis/pdh/options"
 	"github.com/duyunis/pdh/relay"
+	"github.com/duyunis/pdh/tools"
 	"github.com/spf13/cobra"
 	"log"
+	"os"
+	"time"
 )
 
-var opt = &options.RelayOptions{}
+var (
+	opt             = &options.RelayOptions{}
+	storeQuota      string
+	maxChannelBytes string
+	maxChannelRate  string
+)
 
 var Cmd = &cobra.Command{
 	Use:   "relay",
 	Short: "Start your own relay",
 	Run: func(cmd *cobra.Command, args []string) {
-		re := relay.NewRelay(opt)
-		err := re.Run()
+		switch opt.Output {
+		case "text":
+		case "json":
+			log.SetFlags(0)
+			log.SetOutput(event.NewJSONLines(os.Stdout))
+		default:
+			log.Printf("unsupported output: %s\n", opt.Output)
+			os.Exit(1)
+		}
+		opt.StoreQuota = parseSize("--store-quota", storeQuota)
+		opt.MaxChannelBytes = parseSize("--max-channel-bytes", maxChannelBytes)
+		opt.MaxChannelRate = parseSize("--max-channel-rate", maxChannelRate)
+		re, err := relay.NewRelay(opt)
+		if err != nil {
+			log.Printf("start relay error: %s\n", err)
+			return
+		}
+		err = re.Run()
 		if err != nil {
 			log.Printf("start relay error: %s\n", err)
 		}
 	},
 }
 
+// parseSize parses the size given to a flag, it exits on an invalid size
+func parseSize(flag string, value string) int64 {
+	n, err := tools.ParseByteCount(value)
+	if err != nil {
+		log.Printf("invalid %s: %s\n", flag, err)
+		os.Exit(1)
+	}
+	return n
+}
+
 func init() {
 	Cmd.PersistentFlags().StringVarP(&opt.RelayHost, "host", "", "0.0.0.0", "relay host")
 	Cmd.PersistentFlags().StringVarP(&opt.RelayPort, "port", "", "50051", "relay port")
+	Cmd.PersistentFlags().StringVarP(&opt.TLSCert, "tls-cert", "", "", "certificate (PEM) to serve TLS with")
+	Cmd.PersistentFlags().StringVarP(&opt.TLSKey, "tls-key", "", "", "private key (PEM) of the TLS certificate")
+	Cmd.PersistentFlags().StringVarP(&opt.AuthFile, "auth-file", "", "", "file of name:token lines, creating a channel requires one of the tokens")
+	Cmd.PersistentFlags().StringVarP(&opt.MetricsAddress, "metrics", "", "", "address to serve prometheus metrics at /metrics, like :9100")
+	Cmd.PersistentFlags().StringVarP(&opt.Output, "output", "", "text", "log format, text or json (newline delimited)")
+	Cmd.PersistentFlags().BoolVarP(&opt.AuthJoin, "auth-join", "", false, "also require a token to join a channel (default: false)")
+	Cmd.PersistentFlags().StringVarP(&opt.StoreDir, "store", "", "", "folder to keep transfers senders store for receivers that come later, storing is off without it")
+	Cmd.PersistentFlags().StringVarP(&storeQuota, "store-quota", "", "10GB", "max size of all stored transfers")
+	Cmd.PersistentFlags().DurationVarP(&opt.StoreMaxAge, "store-max-age", "", 24*time.Hour, "longest time a stored transfer is kept")
+	Cmd.PersistentFlags().IntVarP(&opt.MaxChannels, "max-channels", "", 0, "max channels open at once, each extra stream of a sender and each upload or download of a stored transfer is a channel, 0 is unlimited")
+	Cmd.PersistentFlags().IntVarP(&opt.MaxChannelsPerIP, "max-channels-per-ip", "", 0, "max channels open at once by one client address, 0 is unlimited")
+	Cmd.PersistentFlags().StringVarP(&maxChannelBytes, "max-channel-bytes", "", "0", "max bytes a transfer forwards through the relay, like 10GB, 0 is unlimited")
+	Cmd.PersistentFlags().StringVarP(&maxChannelRate, "max-channel-rate", "", "0", "max bytes per second a transfer forwards through the relay, like 10MB, 0 is unlimited")
+	Cmd.PersistentFlags().IntVarP(&opt.MaxJoinsPerMinute, "max-joins-per-minute", "", 0, "max channel joins and fetches of one client address per minute, 0 is unlimited")
 }
@@ -1,30 +1,119 @@
 package send
 
 import (
+	"context"
+	"fmt"
 	"github.com/duyunis/pdh/common"
-	"github.com/duyunis/pdh/options"
-	"github.com/duyunis/pdh/sender"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/event"
 	"github.com/duyunis/pdh/tools"
+	"github.com/duyunis/pdh/transfer"
 	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-var opt = &options.SenderOptions{}
+var opt = &transfer.SendOptions{}
+
+// storeCodeLength and storePhraseLength keep enough of a generated code out of the channel name
+// to store a transfer, see crypt.StoreSecretBits
+const (
+	storeCodeLength   = 8
+	storePhraseLength = 15
+)
 
 var Cmd = &cobra.Command{
 	Use:   "send",
 	Short: "Send file(s), or folder (see options with pdh send -h)",
 	Run: func(cmd *cobra.Command, args []string) {
-		files := tools.GetAbsolutePaths(args)
-		opt.ShareCode = tools.GenRandStr(4, "-")
-		fileSender := sender.NewSender(opt)
-		fileSender.Send(files)
+		var jsonLines *event.JSONLines
+		switch opt.Output {
+		case "text":
+			opt.Logger = tools.ConsoleLogger{}
+			opt.Observer = event.NewProgressBars("sent")
+		case "json":
+			jsonLines = event.NewJSONLines(os.Stdout)
+			opt.Logger = jsonLines
+			opt.Observer = jsonLines
+		default:
+			tools.Println(tools.Red, fmt.Sprintf("unsupported output: %s", opt.Output))
+			os.Exit(1)
+		}
+		exit := func(result *transfer.Result, err error) {
+			status := 0
+			if err != nil {
+				status = 1
+			}
+			if jsonLines != nil {
+				jsonLines.Summary(result, err, status)
+			} else if err != nil {
+				tools.Println(tools.Red, fmt.Sprintf("Send Failed! %s", err))
+			}
+			os.Exit(status)
+		}
+
+		var files []string
+		if opt.Text == "-" {
+			// read the text from stdin
+			text, err := io.ReadAll(io.LimitReader(os.Stdin, common.MaxTextSize+1))
+			if err != nil {
+				exit(nil, fmt.Errorf("read text error: %w", err))
+			}
+			opt.Text = string(text)
+		} else if len(args) == 1 && args[0] == "-" {
+			// read from stdin
+			files = args
+		} else {
+			files = tools.GetAbsolutePaths(args)
+		}
+		if tools.IsBlank(opt.ShareCode) {
+			if opt.Store && !cmd.Flags().Changed("code-length") {
+				// the whole secret part of a stored transfer is guessed offline
+				opt.CodeLength = storeCodeLength
+				if opt.Phrase {
+					opt.CodeLength = storePhraseLength
+				}
+			}
+			code, err := transfer.NewCode(opt.CodeLength, opt.Phrase)
+			if err != nil {
+				exit(nil, fmt.Errorf("generate share code error: %w", err))
+			}
+			opt.ShareCode = code
+		} else {
+			opt.ShareCode = crypt.NormalizeCode(opt.ShareCode)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM)
+		defer stop()
+		exit(transfer.Send(ctx, opt, files))
 	},
 }
 
 func init() {
 	Cmd.PersistentFlags().StringVarP(&opt.ShareCode, "shareCode", "c", "", "code used to connect to relay")
+	Cmd.PersistentFlags().IntVarP(&opt.CodeLength, "code-length", "", crypt.DefaultCodeLength, "number of groups (16 bits each), or words with --phrase (8 bits each), of the generated share code")
+	Cmd.PersistentFlags().BoolVarP(&opt.Phrase, "phrase", "", false, "generate the share code as a phrase like 7-apple-river-zebra (default: false)")
 	Cmd.PersistentFlags().BoolVarP(&opt.Zip, "zip", "", false, "zip folder before sending (default: false)")
+	Cmd.PersistentFlags().StringVarP(&opt.Archive, "archive", "", "", "stream each folder as a single archive while sending, tar or tar.zst")
 	Cmd.PersistentFlags().StringVarP(&opt.Relay, "relay", "", common.PublicRelay, "relay address")
+	Cmd.PersistentFlags().StringVarP(&opt.RelayCA, "relay-ca", "", "", "CA certificate (PEM) to verify the relay with, connects with TLS")
+	Cmd.PersistentFlags().StringVarP(&opt.RelayToken, "relay-token", "", "", "access token of the relay")
+	Cmd.PersistentFlags().BoolVarP(&opt.RelayTLS, "relay-tls", "", false, "connect to the relay with TLS verified by the system roots (default: false)")
 	Cmd.PersistentFlags().BoolVarP(&opt.LocalNetwork, "local", "", false, "use local network (default: false)")
 	Cmd.PersistentFlags().StringVarP(&opt.LocalPort, "local-port", "", "6880", "effect when the local network is enabled")
+	Cmd.PersistentFlags().IntVarP(&opt.Streams, "streams", "", 1, "number of parallel streams used to send file data")
+	Cmd.PersistentFlags().IntVarP(&opt.Receivers, "receivers", "", 1, "number of receivers that can receive with the share code, each one on its own")
+	Cmd.PersistentFlags().BoolVarP(&opt.Store, "store", "", false, "upload to the relay and exit, the receiver downloads later with the share code (default: false)")
+	Cmd.PersistentFlags().DurationVarP(&opt.Expire, "expire", "", 24*time.Hour, "how long the relay keeps a stored transfer, at most what the relay allows")
+	Cmd.PersistentFlags().IntVarP(&opt.MaxDownloads, "max-downloads", "", 1, "how many times a stored transfer can be downloaded")
+	Cmd.PersistentFlags().StringVarP(&opt.Text, "text", "t", "", "send text instead of files, - reads it from stdin")
+	Cmd.PersistentFlags().StringVarP(&opt.Compression, "compression", "", "zstd", "compression of the file data (none, flate, zstd, lz4), chunks and files that don't shrink are sent as they are")
+	Cmd.PersistentFlags().IntVarP(&opt.CompressionLevel, "compression-level", "", 0, "level of the compression, 0 is the default of the codec")
+	Cmd.PersistentFlags().StringVarP(&opt.HashAlgorithm, "hash", "", "xxhash", "hash algorithm used to verify files (imohash, md5, xxhash)")
+	Cmd.PersistentFlags().StringSliceVarP(&opt.Exclude, "exclude", "", nil, "leave out files and folders of sent folders matching these globs, like node_modules or build/**/*.o")
+	Cmd.PersistentFlags().StringSliceVarP(&opt.Include, "include", "", nil, "only send the files of sent folders matching these globs, --exclude wins")
+	Cmd.PersistentFlags().BoolVarP(&opt.RespectGitignore, "respect-gitignore", "", false, "leave out what .gitignore and .pdhignore files ignore, and .git folders (default: false)")
+	Cmd.PersistentFlags().StringVarP(&opt.Output, "output", "", "text", "output format, text or json (newline delimited events)")
 }
@@ -12,4 +12,8 @@ const (
 	//PublicRelay      = "127.0.0.1:50051"
 	DefaultLocalPort = "6880"
 	MaxBufferSize    = 1024 * 64
+	MaxStreams       = 16
+	MaxReceivers     = 64
+	MaxTextSize      = 1024 * 1024
+	TokenMetadataKey = "pdh-token"
 )
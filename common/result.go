@@ -0,0 +1,24 @@
+package common
+
+// Result is what a transfer did, the paths of a receiver are in its receive path
+type Result struct {
+	ShareCode string
+	// Files are the files sent, or written by the receiver
+	Files []string
+	// Verified are the files that matched the hash of the sender
+	Verified []string
+	// Linked are the symlinks created by the receiver
+	Linked []string
+	// Skipped are the files the receiver already had or didn't want
+	Skipped []string
+	// Failed are the files that couldn't be written or failed verification
+	Failed []string
+	// Bytes of file data sent or received
+	Bytes int64
+	// Text is the text sent or received instead of files
+	Text string
+	// Receivers are the results of each receiver when sending to several
+	Receivers []Result
+	// Error is why the transfer to one of several receivers failed
+	Error string
+}
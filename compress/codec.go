@@ -0,0 +1,268 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// names of the codecs
+const (
+	None  = "none"
+	Flate = "flate"
+	Zstd  = "zstd"
+	LZ4   = "lz4"
+)
+
+// maxChunkSize limits the decompressed size of a chunk, so a small chunk can't expand without bounds
+const maxChunkSize = 4 * 1024 * 1024
+
+// Codec compresses and decompresses chunks of file data, it's used by several streams at once
+type Codec interface {
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// NewCodec creates a codec, level 0 is the default level of the codec
+type NewCodec func(level int) (Codec, error)
+
+var codecs = map[string]NewCodec{
+	Flate: newFlate,
+	Zstd:  newZstd,
+	LZ4:   newLZ4,
+}
+
+// Register adds a codec the receiver offers to the sender, call it before any transfer
+func Register(name string, newCodec NewCodec) {
+	codecs[name] = newCodec
+}
+
+// Names are the registered codecs, sorted
+func Names() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get creates the codec by name, it's nil for none
+func Get(name string, level int) (Codec, error) {
+	if name == None {
+		return nil, nil
+	}
+	newCodec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression: %s", name)
+	}
+	return newCodec(level)
+}
+
+// incompressible are extensions of files that are compressed already
+var incompressible = map[string]bool{
+	".7z": true, ".avi": true, ".br": true, ".bz2": true, ".docx": true, ".flac": true, ".gif": true,
+	".gz": true, ".heic": true, ".jpeg": true, ".jpg": true, ".lz4": true, ".m4a": true, ".mkv": true,
+	".mov": true, ".mp3": true, ".mp4": true, ".ogg": true, ".png": true, ".pptx": true, ".rar": true,
+	".tgz": true, ".webm": true, ".webp": true, ".xlsx": true, ".xz": true, ".zip": true, ".zst": true,
+}
+
+// Compressible reports whether compressing a file of the name may pay off
+func Compressible(name string) bool {
+	return !incompressible[strings.ToLower(filepath.Ext(name))]
+}
+
+const (
+	// minSaving is the fraction a chunk has to shrink by to be sent compressed
+	minSaving = 16
+	// maxMisses is the number of chunks that don't shrink after which a file is sent as it is
+	maxMisses = 8
+)
+
+// Chunks compresses the chunks of a file, chunks that don't shrink are sent as they are,
+// and if the first chunks of the file all don't it stops trying. A nil Chunks compresses nothing.
+type Chunks struct {
+	codec  Codec
+	hits   int32
+	misses int32
+}
+
+func NewChunks(codec Codec) *Chunks {
+	if codec == nil {
+		return nil
+	}
+	return &Chunks{codec: codec}
+}
+
+// Compress returns the chunk to send and whether it's compressed
+func (c *Chunks) Compress(src []byte) ([]byte, bool) {
+	if c == nil || len(src) == 0 {
+		return src, false
+	}
+	if atomic.LoadInt32(&c.hits) == 0 && atomic.LoadInt32(&c.misses) >= maxMisses {
+		return src, false
+	}
+	compressed, err := c.codec.Compress(src)
+	if err != nil || len(compressed) > len(src)-len(src)/minSaving {
+		atomic.AddInt32(&c.misses, 1)
+		return src, false
+	}
+	atomic.AddInt32(&c.hits, 1)
+	return compressed, true
+}
+
+type flateCodec struct {
+	level int
+}
+
+func newFlate(level int) (Codec, error) {
+	if level == 0 {
+		level = flate.BestSpeed
+	}
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, fmt.Errorf("flate level must be between %d and %d", flate.HuffmanOnly, flate.BestCompression)
+	}
+	return &flateCodec{level: level}, nil
+}
+
+func (f *flateCodec) Compress(src []byte) ([]byte, error) {
+	compressed := new(bytes.Buffer)
+	writer, err := flate.NewWriter(compressed, f.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(src); err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	return compressed.Bytes(), err
+}
+
+func (f *flateCodec) Decompress(src []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(src))
+	defer reader.Close()
+	return readLimited(reader)
+}
+
+// zstdCodec creates its encoder on the first use, a receiver only decompresses
+type zstdCodec struct {
+	speed       zstd.EncoderLevel
+	encoder     *zstd.Encoder
+	encoderErr  error
+	encoderOnce sync.Once
+}
+
+var (
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+	zstdDecoderOnce sync.Once
+)
+
+func newZstd(level int) (Codec, error) {
+	if level < 0 || level > 22 {
+		return nil, errors.New("zstd level must be between 1 and 22")
+	}
+	z := &zstdCodec{speed: zstd.SpeedDefault}
+	if level != 0 {
+		z.speed = zstd.EncoderLevelFromZstd(level)
+	}
+	return z, nil
+}
+
+func (z *zstdCodec) Compress(src []byte) ([]byte, error) {
+	z.encoderOnce.Do(func() {
+		z.encoder, z.encoderErr = zstd.NewWriter(nil, zstd.WithEncoderLevel(z.speed))
+	})
+	if z.encoderErr != nil {
+		return nil, z.encoderErr
+	}
+	return z.encoder.EncodeAll(src, nil), nil
+}
+
+func (z *zstdCodec) Decompress(src []byte) ([]byte, error) {
+	zstdDecoderOnce.Do(func() {
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxChunkSize))
+	})
+	if zstdDecoderErr != nil {
+		return nil, zstdDecoderErr
+	}
+	return zstdDecoder.DecodeAll(src, nil)
+}
+
+// lz4Codec writes the size of the chunk before the lz4 block, the block format doesn't have it
+type lz4Codec struct {
+	level       lz4.CompressionLevel
+	compressors sync.Pool
+}
+
+func newLZ4(level int) (Codec, error) {
+	if level < 0 || level > 9 {
+		return nil, errors.New("lz4 level must be between 1 and 9")
+	}
+	c := &lz4Codec{}
+	if level > 0 {
+		// lz4.Level1 to lz4.Level9
+		c.level = lz4.CompressionLevel(1 << (8 + level))
+	}
+	return c, nil
+}
+
+func (l *lz4Codec) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, binary.MaxVarintLen64+lz4.CompressBlockBound(len(src)))
+	header := binary.PutUvarint(dst, uint64(len(src)))
+	var (
+		n   int
+		err error
+	)
+	if l.level == lz4.Fast {
+		compressor, ok := l.compressors.Get().(*lz4.Compressor)
+		if !ok {
+			compressor = &lz4.Compressor{}
+		}
+		n, err = compressor.CompressBlock(src, dst[header:])
+		l.compressors.Put(compressor)
+	} else {
+		compressor, ok := l.compressors.Get().(*lz4.CompressorHC)
+		if !ok {
+			compressor = &lz4.CompressorHC{Level: l.level}
+		}
+		n, err = compressor.CompressBlock(src, dst[header:])
+		l.compressors.Put(compressor)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dst[:header+n], nil
+}
+
+func (l *lz4Codec) Decompress(src []byte) ([]byte, error) {
+	size, header := binary.Uvarint(src)
+	if header <= 0 || size > maxChunkSize {
+		return nil, errors.New("invalid lz4 chunk size")
+	}
+	dst := make([]byte, size)
+	n, err := lz4.UncompressBlock(src[header:], dst)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n], nil
+}
+
+// readLimited reads a decompressed chunk up to maxChunkSize
+func readLimited(reader io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, maxChunkSize+1))
+	if err == nil && len(data) > maxChunkSize {
+		err = errors.New("decompressed chunk is too large")
+	}
+	return data, err
+}
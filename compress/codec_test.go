@@ -0,0 +1,177 @@
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := map[string][]byte{
+		"empty":    {},
+		"text":     bytes.Repeat([]byte("peer to peer file transfer "), 4096),
+		"random":   random,
+		"one byte": {42},
+	}
+	cases := []struct {
+		name  string
+		level int
+	}{
+		{Flate, 0},
+		{Flate, 9},
+		{Zstd, 0},
+		{Zstd, 19},
+		{LZ4, 0},
+		{LZ4, 9},
+	}
+	for _, c := range cases {
+		codec, err := Get(c.name, c.level)
+		if err != nil {
+			t.Errorf("Get(%s, %d) error: %v", c.name, c.level, err)
+			continue
+		}
+		for input, data := range inputs {
+			compressed, err := codec.Compress(data)
+			if err != nil {
+				t.Errorf("%s level %d: compress %s error: %v", c.name, c.level, input, err)
+				continue
+			}
+			decompressed, err := codec.Decompress(compressed)
+			if err != nil {
+				t.Errorf("%s level %d: decompress %s error: %v", c.name, c.level, input, err)
+				continue
+			}
+			if !bytes.Equal(decompressed, data) {
+				t.Errorf("%s level %d: %s changed in the round trip", c.name, c.level, input)
+			}
+		}
+	}
+}
+
+func TestCodecRefusesLargeChunks(t *testing.T) {
+	large := make([]byte, maxChunkSize+1)
+	for _, name := range []string{Flate, Zstd, LZ4} {
+		codec, _ := Get(name, 0)
+		compressed, err := codec.Compress(large)
+		if err != nil {
+			t.Errorf("%s: compress error: %v", name, err)
+			continue
+		}
+		if _, err = codec.Decompress(compressed); err == nil {
+			t.Errorf("%s: a chunk larger than %d bytes was decompressed", name, maxChunkSize)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	cases := []struct {
+		name    string
+		level   int
+		wantNil bool
+		wantErr bool
+	}{
+		{None, 0, true, false},
+		{Flate, 0, false, false},
+		{Flate, 10, false, true},
+		{Zstd, 22, false, false},
+		{Zstd, 23, false, true},
+		{Zstd, -1, false, true},
+		{LZ4, 9, false, false},
+		{LZ4, 10, false, true},
+		{"brotli", 0, true, true},
+		{"", 0, true, true},
+	}
+	for _, c := range cases {
+		codec, err := Get(c.name, c.level)
+		if (err != nil) != c.wantErr {
+			t.Errorf("Get(%q, %d) error = %v, want error %v", c.name, c.level, err, c.wantErr)
+		}
+		if err == nil && (codec == nil) != c.wantNil {
+			t.Errorf("Get(%q, %d) = %v, want nil %v", c.name, c.level, codec, c.wantNil)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	want := []string{Flate, LZ4, Zstd}
+	names := Names()
+	if len(names) != len(want) {
+		t.Fatalf("Names() = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Names() = %v, want %v", names, want)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"notes.txt", true},
+		{"Makefile", true},
+		{"photo.JPG", false},
+		{"backup.tar.gz", false},
+		{"movie.mkv", false},
+		{"archive.zst", false},
+	}
+	for _, c := range cases {
+		if got := Compressible(c.name); got != c.want {
+			t.Errorf("Compressible(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	codec, _ := Get(Zstd, 0)
+	text := bytes.Repeat([]byte("compressible "), 1024)
+	random := make([]byte, 16*1024)
+	rand.New(rand.NewSource(2)).Read(random)
+
+	cases := []struct {
+		name   string
+		chunks [][]byte
+		// compressed is whether each chunk is sent compressed
+		compressed []bool
+	}{
+		{"compressible", [][]byte{text, text}, []bool{true, true}},
+		{"empty chunk", [][]byte{{}}, []bool{false}},
+		{
+			"stops after misses",
+			[][]byte{random, random, random, random, random, random, random, random, text},
+			[]bool{false, false, false, false, false, false, false, false, false},
+		},
+		{
+			"keeps trying after a hit",
+			[][]byte{text, random, random, random, random, random, random, random, random, text},
+			[]bool{true, false, false, false, false, false, false, false, false, true},
+		},
+	}
+	for _, c := range cases {
+		chunks := NewChunks(codec)
+		for i, chunk := range c.chunks {
+			data, compressed := chunks.Compress(chunk)
+			if compressed != c.compressed[i] {
+				t.Errorf("%s: chunk %d compressed = %v, want %v", c.name, i, compressed, c.compressed[i])
+			}
+			if !compressed && !bytes.Equal(data, chunk) {
+				t.Errorf("%s: chunk %d isn't sent as it is", c.name, i)
+			}
+		}
+	}
+}
+
+func TestNilChunks(t *testing.T) {
+	chunks := NewChunks(nil)
+	if chunks != nil {
+		t.Fatalf("NewChunks(nil) = %v, want nil", chunks)
+	}
+	data := []byte("data")
+	if got, compressed := chunks.Compress(data); compressed || !bytes.Equal(got, data) {
+		t.Errorf("nil Chunks compressed the chunk")
+	}
+}
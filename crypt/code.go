@@ -0,0 +1,128 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/duyunis/pdh/tools"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultCodeLength is the number of groups, or words, of a generated share code
+	DefaultCodeLength = 4
+	// MinCodeLength keeps a part of the code out of the channel name for the PAKE
+	MinCodeLength = 2
+	// MaxCodeLength bounds the length of a generated share code
+	MaxCodeLength = 16
+
+	// phraseNumberLimit bounds the number that starts a phrase
+	phraseNumberLimit = 1000
+)
+
+// codeWords are the words of a share code phrase, each word carries 8 bits
+var codeWords = [256]string{
+	"acid", "acorn", "actor", "adult", "agent", "alarm", "album", "alley", "amber", "angle",
+	"ankle", "apple", "apron", "arena", "armor", "arrow", "atlas", "attic", "audio",
+	"award", "bacon", "badge", "bagel", "baker", "bamboo", "banjo", "barn", "basil",
+	"basket", "beach", "beard", "beetle", "bench", "berry", "bike", "bison", "blade",
+	"blanket", "blaze", "board", "boat", "bonus", "book", "boot", "bottle", "bounce",
+	"bowl", "brain", "brass", "bread", "brick", "bridge", "brook", "broom", "brush",
+	"bubble", "bucket", "bugle", "bunny", "butter", "button", "cabin", "cable", "cactus",
+	"camel", "camera", "candle", "candy", "canoe", "canyon", "carbon", "carpet", "carrot",
+	"castle", "cattle", "cedar", "cello", "chalk", "cheese", "cherry", "chess", "chicken",
+	"circle", "citrus", "clock", "cloud", "clover", "coast", "cobra", "cocoa", "comet",
+	"copper", "coral", "cotton", "cousin", "coyote", "crane", "crayon", "crown", "daisy",
+	"dancer", "delta", "desert", "diamond", "dinner", "doctor", "dolphin", "donkey",
+	"dragon", "drawer", "dream", "drum", "eagle", "earth", "echo", "elbow", "engine",
+	"falcon", "feather", "fence", "ferry", "fiddle", "finger", "flame", "flute", "forest",
+	"fossil", "fox", "frog", "galaxy", "garden", "garlic", "gecko", "giant", "ginger",
+	"globe", "goat", "gold", "grape", "gravel", "guitar", "hammer", "harbor", "harvest",
+	"hazel", "helmet", "hero", "honey", "horse", "hotel", "husky", "igloo", "island",
+	"ivory", "jacket", "jaguar", "jelly", "jewel", "jungle", "kettle", "kite", "koala",
+	"ladder", "lagoon", "lamp", "lemon", "leopard", "lily", "lion", "lizard", "magnet",
+	"mango", "maple", "marble", "meadow", "melon", "meteor", "mirror", "monkey", "moose",
+	"motor", "muffin", "museum", "needle", "nest", "noodle", "ocean", "olive", "onion",
+	"orange", "orbit", "otter", "owl", "paddle", "panda", "paper", "parrot", "peach",
+	"peanut", "pebble", "pencil", "pepper", "piano", "pickle", "pillow", "pilot", "planet",
+	"plum", "pocket", "pony", "potato", "puzzle", "quartz", "rabbit", "radio", "rainbow",
+	"raven", "ribbon", "river", "robot", "rocket", "saddle", "salmon", "sandal", "shadow",
+	"shark", "shell", "silver", "sketch", "skunk", "sled", "snake", "spider", "spoon",
+	"squid", "statue", "stone", "sugar", "summer", "sunset", "swan", "table", "tiger",
+	"toast", "tomato", "tulip", "tunnel", "turtle", "velvet", "violin", "walnut", "whale",
+	"window", "winter", "wizard", "zebra",
+}
+
+var codeWordIndex = func() map[string]bool {
+	index := make(map[string]bool, len(codeWords))
+	for _, word := range codeWords {
+		index[word] = true
+	}
+	return index
+}()
+
+// NewCode generates a share code of length 16 bit hex groups, or a phrase of a number and
+// length words (8 bits each) like 7-apple-river-zebra, which is easier to read aloud.
+func NewCode(length int, phrase bool) (string, error) {
+	if length < MinCodeLength || length > MaxCodeLength {
+		return "", fmt.Errorf("share code length must be between %d and %d", MinCodeLength, MaxCodeLength)
+	}
+	if !phrase {
+		return tools.GenRandStr(length, "-")
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(phraseNumberLimit))
+	if err != nil {
+		return "", err
+	}
+	parts := []string{n.String()}
+	index := make([]byte, length)
+	if _, err = rand.Read(index); err != nil {
+		return "", err
+	}
+	for _, i := range index {
+		parts = append(parts, codeWords[i])
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+// NormalizeCode lowercases the share code and joins words separated by spaces with dashes
+func NormalizeCode(code string) string {
+	return strings.Join(strings.Fields(strings.ToLower(code)), "-")
+}
+
+// ValidateCode checks the share code is made of hex groups, or is a phrase of a number and known words
+func ValidateCode(code string) error {
+	parts := strings.Split(code, "-")
+	hexErr := validateHexCode(parts)
+	if hexErr == nil {
+		return nil
+	}
+	if _, err := strconv.Atoi(parts[0]); err == nil && len(parts) > 1 {
+		if len(parts)-1 < MinCodeLength {
+			return fmt.Errorf("share code needs at least %d words", MinCodeLength)
+		}
+		for _, word := range parts[1:] {
+			if !codeWordIndex[word] {
+				return fmt.Errorf("unknown word %q in share code", word)
+			}
+		}
+		return nil
+	}
+	return hexErr
+}
+
+// validateHexCode checks the parts of the share code are 16 bit hex groups,
+// a hex code may start with a group of digits only like a phrase does.
+func validateHexCode(parts []string) error {
+	if len(parts) < MinCodeLength {
+		return fmt.Errorf("share code needs at least %d groups", MinCodeLength)
+	}
+	for _, part := range parts {
+		if len(part) != 4 || strings.Trim(part, "0123456789abcdef") != "" {
+			return errors.New("share code must be groups of 4 hex digits, like 1a2b-3c4d-5e6f-7a8b")
+		}
+	}
+	return nil
+}
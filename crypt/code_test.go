@@ -0,0 +1,156 @@
+package crypt
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateCode(t *testing.T) {
+	cases := []struct {
+		code    string
+		wantErr bool
+	}{
+		{"1a2b-3c4d", false},
+		{"1a2b-3c4d-5e6f-7a8b", false},
+		{"1234-5678", false},
+		{"7-apple-river", false},
+		{"999-apple-river-zebra", false},
+		{"0-window-winter-wizard", false},
+		{"1a2b", true},
+		{"1a2b-", true},
+		{"1a2b-3c4", true},
+		{"1a2b-3c4d5", true},
+		{"1A2B-3C4D", true},
+		{"1a2b-3c4g", true},
+		{"1a2b_3c4d", true},
+		{"7-apple", true},
+		{"7-Apple-river", true},
+		{"7-apple-rivers", true},
+		{"7-apple--river", true},
+		{"apple-river-zebra", true},
+		{"7", true},
+		{"", true},
+	}
+	for _, c := range cases {
+		if err := ValidateCode(c.code); (err != nil) != c.wantErr {
+			t.Errorf("ValidateCode(%q) error = %v, want error %v", c.code, err, c.wantErr)
+		}
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	cases := []struct {
+		code string
+		want string
+	}{
+		{"1A2B-3C4D", "1a2b-3c4d"},
+		{"7 apple river", "7-apple-river"},
+		{"  7  Apple\tRIVER ", "7-apple-river"},
+		{"7-apple-river", "7-apple-river"},
+	}
+	for _, c := range cases {
+		got := NormalizeCode(c.code)
+		if got != c.want {
+			t.Errorf("NormalizeCode(%q) = %q, want %q", c.code, got, c.want)
+		}
+		if err := ValidateCode(got); err != nil {
+			t.Errorf("ValidateCode(NormalizeCode(%q)) error: %v", c.code, err)
+		}
+	}
+}
+
+func TestNewCode(t *testing.T) {
+	cases := []struct {
+		length  int
+		phrase  bool
+		wantErr bool
+	}{
+		{MinCodeLength, false, false},
+		{DefaultCodeLength, false, false},
+		{MaxCodeLength, false, false},
+		{MinCodeLength, true, false},
+		{DefaultCodeLength, true, false},
+		{MaxCodeLength, true, false},
+		{MinCodeLength - 1, false, true},
+		{MaxCodeLength + 1, false, true},
+		{0, true, true},
+		{MaxCodeLength + 1, true, true},
+	}
+	for _, c := range cases {
+		code, err := NewCode(c.length, c.phrase)
+		if (err != nil) != c.wantErr {
+			t.Errorf("NewCode(%d, %v) error = %v, want error %v", c.length, c.phrase, err, c.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if err = ValidateCode(code); err != nil {
+			t.Errorf("NewCode(%d, %v) = %q is invalid: %v", c.length, c.phrase, code, err)
+		}
+		parts := strings.Split(code, "-")
+		want := c.length
+		if c.phrase {
+			want++
+			if n, err := strconv.Atoi(parts[0]); err != nil || n < 0 || n >= phraseNumberLimit {
+				t.Errorf("NewCode(%d, %v) = %q doesn't start with a number below %d", c.length, c.phrase, code, phraseNumberLimit)
+			}
+		}
+		if len(parts) != want {
+			t.Errorf("NewCode(%d, %v) = %q has %d parts, want %d", c.length, c.phrase, code, len(parts), want)
+		}
+	}
+}
+
+func TestCodeWordsAreUnique(t *testing.T) {
+	// each word carries 8 bits only if all 256 differ
+	if len(codeWordIndex) != len(codeWords) {
+		t.Errorf("%d of the %d code words are unique", len(codeWordIndex), len(codeWords))
+	}
+	for _, word := range codeWords {
+		if word == "" || strings.ToLower(word) != word || strings.ContainsAny(word, "- ") {
+			t.Errorf("code word %q can't be typed back", word)
+		}
+	}
+}
+
+func TestNewCodeEntropy(t *testing.T) {
+	const n = 2000
+	cases := []struct {
+		phrase bool
+		// symbols is the number of different groups, or words, expected to show up
+		symbols int
+	}{
+		{false, 16},
+		{true, len(codeWords)},
+	}
+	for _, c := range cases {
+		codes := make(map[string]bool, n)
+		symbols := make(map[string]bool)
+		for i := 0; i < n; i++ {
+			code, err := NewCode(DefaultCodeLength, c.phrase)
+			if err != nil {
+				t.Fatalf("NewCode error: %v", err)
+			}
+			if codes[code] {
+				t.Errorf("NewCode(%d, %v) repeated %q", DefaultCodeLength, c.phrase, code)
+			}
+			codes[code] = true
+			parts := strings.Split(code, "-")
+			if c.phrase {
+				for _, word := range parts[1:] {
+					symbols[word] = true
+				}
+			} else {
+				for _, digit := range strings.Join(parts, "") {
+					symbols[string(digit)] = true
+				}
+			}
+		}
+		// thousands of draws leave none of the symbols out unless the source is biased
+		if len(symbols) != c.symbols {
+			t.Errorf("NewCode(%d, %v) used %d different symbols, want %d", DefaultCodeLength, c.phrase, len(symbols), c.symbols)
+		}
+	}
+}
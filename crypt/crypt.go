@@ -0,0 +1,127 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"strings"
+)
+
+const (
+	// Curve is the elliptic curve used by the PAKE
+	Curve = "siec"
+
+	saltSize         = 8
+	keySize          = 32
+	pbkdf2Iterations = 100
+
+	// StoreSecretBits is the least part of a share code kept from the relay a stored transfer needs,
+	// whoever gets the stored data can try codes offline without asking the sender
+	StoreSecretBits = 64
+	storeSaltSize   = 16
+)
+
+// New generates a new key based on a passphrase and salt, a random salt is generated when salt is empty
+func New(passphrase []byte, salt []byte) (key []byte, newSalt []byte, err error) {
+	if len(passphrase) < 1 {
+		err = errors.New("need more than that for passphrase")
+		return
+	}
+	if salt == nil {
+		newSalt = make([]byte, saltSize)
+		if _, err = rand.Read(newSalt); err != nil {
+			return
+		}
+	} else {
+		newSalt = salt
+	}
+	key = pbkdf2.Key(passphrase, newSalt, pbkdf2Iterations, keySize, sha256.New)
+	return
+}
+
+// Encrypt will encrypt using the pre-generated key, the nonce is prepended to the result
+func Encrypt(plaintext []byte, key []byte) (encrypted []byte, err error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	encrypted = aesgcm.Seal(nonce, nonce, plaintext, nil)
+	return
+}
+
+// Decrypt using the pre-generated key
+func Decrypt(encrypted []byte, key []byte) (plaintext []byte, err error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return
+	}
+	if len(encrypted) < aesgcm.NonceSize() {
+		err = errors.New("incorrect passphrase")
+		return
+	}
+	nonce, ciphertext := encrypted[:aesgcm.NonceSize()], encrypted[aesgcm.NonceSize():]
+	plaintext, err = aesgcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		err = errors.New("incorrect passphrase")
+	}
+	return
+}
+
+// ChannelName derives the name of the relay channel from the share code.
+// Only the first half of the code is used, so the relay never learns
+// the whole code that keys the PAKE.
+func ChannelName(shareCode string) string {
+	parts := strings.Split(shareCode, "-")
+	if len(parts) > 1 {
+		parts = parts[:(len(parts)+1)/2]
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "-")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// StoreKey derives the key of a transfer stored on the relay from the whole share code, guessing
+// the code is slow since there is no PAKE, a random salt is generated when salt is empty.
+func StoreKey(shareCode string, salt []byte) (key []byte, newSalt []byte, err error) {
+	newSalt = salt
+	if salt == nil {
+		newSalt = make([]byte, storeSaltSize)
+		if _, err = rand.Read(newSalt); err != nil {
+			return
+		}
+	}
+	key = argon2.IDKey([]byte(shareCode), newSalt, 3, 64*1024, 4, keySize)
+	return
+}
+
+// SecretBits is how many bits of the share code are not in its channel name
+func SecretBits(shareCode string) int {
+	parts := strings.Split(shareCode, "-")
+	secret := len(parts) - (len(parts)+1)/2
+	if validateHexCode(parts) == nil {
+		return secret * 16
+	}
+	return secret * 8
+}
+
+// DataChannelName is the name of the relay channel of an extra data stream
+func DataChannelName(shareCode string, index int) string {
+	return fmt.Sprintf("%s.%d", ChannelName(shareCode), index)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,31 @@
+package crypt
+
+import "testing"
+
+func TestSecretBits(t *testing.T) {
+	cases := []struct {
+		code string
+		want int
+	}{
+		{"1a2b-3c4d", 16},
+		{"1a2b-3c4d-5e6f", 16},
+		{"1a2b-3c4d-5e6f-7a8b", 32},
+		{"1a2b-3c4d-5e6f-7a8b-9c0d-1e2f-3a4b-5c6d", 64},
+		{"7-apple-river", 8},
+		{"7-apple-river-zebra", 16},
+		{"7-" + repeat("apple", 15), 64},
+	}
+	for _, c := range cases {
+		if got := SecretBits(c.code); got != c.want {
+			t.Errorf("SecretBits(%q) = %d, want %d", c.code, got, c.want)
+		}
+	}
+}
+
+func repeat(word string, n int) string {
+	s := word
+	for i := 1; i < n; i++ {
+		s += "-" + word
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+// Package event reports the progress of a transfer to programs that use pdh
+package event
+
+import "sync"
+
+type Type int
+
+const (
+	// TransferStarted is sent once the receiver accepted, Files and Size are of all files,
+	// the sender also lists the Names of the files.
+	TransferStarted Type = iota
+	// FileStarted is sent before the data of File is transferred, Bytes is where it resumes
+	FileStarted
+	// BytesTransferred is sent for each chunk, Bytes of File are transferred so far
+	BytesTransferred
+	// FileSkipped is sent when the receiver didn't want File
+	FileSkipped
+	// FileVerified is sent by the receiver when File matched the hash of the sender
+	FileVerified
+	// TransferFailed is sent once with Err when the transfer ended with an error
+	TransferFailed
+	// Completed is sent once when the transfer ended without an error
+	Completed
+	// ShareCodeReady is sent by the sender once the receiver can connect with ShareCode
+	ShareCodeReady
+	// FileCompleted is sent when all data of File is transferred
+	FileCompleted
+	// FileFailed is sent by the receiver with Err when File couldn't be written or verified
+	FileFailed
+)
+
+var typeNames = map[Type]string{
+	TransferStarted:  "TransferStarted",
+	FileStarted:      "FileStarted",
+	BytesTransferred: "BytesTransferred",
+	FileSkipped:      "FileSkipped",
+	FileVerified:     "FileVerified",
+	TransferFailed:   "TransferFailed",
+	Completed:        "Completed",
+	ShareCodeReady:   "ShareCodeReady",
+	FileCompleted:    "FileCompleted",
+	FileFailed:       "FileFailed",
+}
+
+func (t Type) String() string {
+	return typeNames[t]
+}
+
+type Event struct {
+	Type Type
+	// File is the name of the file, empty for events of the whole transfer
+	File string
+	// Files is the number of files of TransferStarted
+	Files int64
+	// Size of the file, or of all files, -1 while the size of a stream is unknown
+	Size      int64
+	Bytes     int64
+	Err       error
+	ShareCode string
+	Names     []string
+	// Receiver numbers the receiver from 1 when sending to several, 0 otherwise
+	Receiver int
+}
+
+// Observer gets the events of a transfer, calls come from the goroutines
+// of the transfer one at a time and should return quickly.
+type Observer interface {
+	OnEvent(e Event)
+}
+
+// ObserverFunc lets a function be an Observer
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) OnEvent(e Event) {
+	f(e)
+}
+
+// Chan sends the events to ch. BytesTransferred events are dropped while ch is full, any other
+// event waits for room, so the transfer stalls until ch is read and the terminal Completed or
+// TransferFailed is never lost.
+func Chan(ch chan<- Event) Observer {
+	return ObserverFunc(func(e Event) {
+		if e.Type != BytesTransferred {
+			ch <- e
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+}
+
+// Emitter passes events to an Observer one at a time, it does nothing without one
+type Emitter struct {
+	sync.Mutex
+	observer Observer
+}
+
+func NewEmitter(observer Observer) *Emitter {
+	return &Emitter{observer: observer}
+}
+
+func (e *Emitter) Emit(event Event) {
+	if e.observer == nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.observer.OnEvent(event)
+}
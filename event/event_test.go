@@ -0,0 +1,41 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChanDropsOnlyProgress(t *testing.T) {
+	ch := make(chan Event, 1)
+	observer := Chan(ch)
+	observer.OnEvent(Event{Type: FileStarted})
+	// ch is full, progress is dropped right away
+	observer.OnEvent(Event{Type: BytesTransferred})
+
+	done := make(chan struct{})
+	go func() {
+		observer.OnEvent(Event{Type: FileCompleted})
+		observer.OnEvent(Event{Type: Completed})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("events were dropped while the channel was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var got []Type
+	for len(got) < 3 {
+		got = append(got, (<-ch).Type)
+	}
+	<-done
+	want := []Type{FileStarted, FileCompleted, Completed}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got events %v, want %v", got, want)
+		}
+	}
+	if len(ch) != 0 {
+		t.Errorf("%d events left, the dropped progress was delivered", len(ch))
+	}
+}
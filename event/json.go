@@ -0,0 +1,179 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/duyunis/pdh/common"
+	"io"
+	"sync"
+	"time"
+)
+
+// progressInterval limits the BytesTransferred lines of a file
+const progressInterval = time.Millisecond * 500
+
+// JSONLines writes events, log messages and the summary as newline delimited JSON,
+// it's an Observer, a tools.Logger and an io.Writer for the log package.
+type JSONLines struct {
+	sync.Mutex
+	w            io.Writer
+	lastProgress time.Time
+}
+
+type jsonEvent struct {
+	Event     string
+	Time      time.Time
+	File      string   `json:"File,omitempty"`
+	Files     int64    `json:"Files,omitempty"`
+	Size      *int64   `json:"Size,omitempty"`
+	Bytes     *int64   `json:"Bytes,omitempty"`
+	Error     string   `json:"Error,omitempty"`
+	ShareCode string   `json:"ShareCode,omitempty"`
+	Names     []string `json:"Names,omitempty"`
+	Receiver  int      `json:"Receiver,omitempty"`
+}
+
+type jsonLog struct {
+	Event   string
+	Time    time.Time
+	Level   string
+	Message string
+}
+
+type jsonSummary struct {
+	Event      string
+	Time       time.Time
+	ShareCode  string   `json:"ShareCode,omitempty"`
+	Files      []string `json:"Files"`
+	Verified   []string `json:"Verified"`
+	Linked     []string `json:"Linked"`
+	Skipped    []string `json:"Skipped"`
+	Failed     []string `json:"Failed"`
+	Bytes      int64
+	Text       string `json:"Text,omitempty"`
+	Error      string `json:"Error,omitempty"`
+	ExitStatus int
+	Receivers  []jsonReceiver `json:"Receivers,omitempty"`
+}
+
+// jsonReceiver is the result of one of several receivers
+type jsonReceiver struct {
+	Receiver int
+	Files    []string `json:"Files"`
+	Skipped  []string `json:"Skipped"`
+	Bytes    int64
+	Error    string `json:"Error,omitempty"`
+}
+
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{w: w}
+}
+
+func (j *JSONLines) OnEvent(e Event) {
+	if e.Type == BytesTransferred {
+		j.Lock()
+		finished := e.Size >= 0 && e.Bytes >= e.Size
+		if !finished && time.Since(j.lastProgress) < progressInterval {
+			j.Unlock()
+			return
+		}
+		j.lastProgress = time.Now()
+		j.Unlock()
+	}
+	je := &jsonEvent{
+		Event:     e.Type.String(),
+		Time:      time.Now(),
+		File:      e.File,
+		Files:     e.Files,
+		ShareCode: e.ShareCode,
+		Names:     e.Names,
+		Receiver:  e.Receiver,
+	}
+	switch e.Type {
+	case TransferStarted, FileCompleted:
+		size := e.Size
+		je.Size = &size
+	case FileStarted, BytesTransferred:
+		size, transferred := e.Size, e.Bytes
+		je.Size, je.Bytes = &size, &transferred
+	}
+	if e.Err != nil {
+		je.Error = e.Err.Error()
+	}
+	j.Encode(je)
+}
+
+func (j *JSONLines) Info(msg string) {
+	j.log("info", msg)
+}
+
+func (j *JSONLines) Warn(msg string) {
+	j.log("warn", msg)
+}
+
+func (j *JSONLines) Error(msg string) {
+	j.log("error", msg)
+}
+
+func (j *JSONLines) log(level string, msg string) {
+	if msg == "" {
+		return
+	}
+	j.Encode(&jsonLog{Event: "Log", Time: time.Now(), Level: level, Message: msg})
+}
+
+// Write takes the lines of the log package
+func (j *JSONLines) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		j.log("info", string(line))
+	}
+	return len(p), nil
+}
+
+// Summary writes the result of the transfer and the exit status of the command
+func (j *JSONLines) Summary(result *common.Result, err error, exitStatus int) {
+	summary := &jsonSummary{
+		Event:      "Summary",
+		Time:       time.Now(),
+		Files:      []string{},
+		Verified:   []string{},
+		Linked:     []string{},
+		Skipped:    []string{},
+		Failed:     []string{},
+		ExitStatus: exitStatus,
+	}
+	if result != nil {
+		summary.ShareCode = result.ShareCode
+		summary.Files = append(summary.Files, result.Files...)
+		summary.Verified = append(summary.Verified, result.Verified...)
+		summary.Linked = append(summary.Linked, result.Linked...)
+		summary.Skipped = append(summary.Skipped, result.Skipped...)
+		summary.Failed = append(summary.Failed, result.Failed...)
+		summary.Bytes = result.Bytes
+		summary.Text = result.Text
+		for i, r := range result.Receivers {
+			summary.Receivers = append(summary.Receivers, jsonReceiver{
+				Receiver: i + 1,
+				Files:    append([]string{}, r.Files...),
+				Skipped:  append([]string{}, r.Skipped...),
+				Bytes:    r.Bytes,
+				Error:    r.Error,
+			})
+		}
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	j.Encode(summary)
+}
+
+// Encode writes v as a line
+func (j *JSONLines) Encode(v any) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	_, _ = j.w.Write(append(line, '\n'))
+}
@@ -0,0 +1,59 @@
+package event
+
+import (
+	"fmt"
+	"github.com/duyunis/pdh/tools"
+	"github.com/duyunis/progress_bar"
+)
+
+// ProgressBars draws a progress bar for each file on the terminal,
+// a stream of unknown size shows the bytes transferred followed by verb.
+// The files of several receivers go at once, they get a line when done instead.
+type ProgressBars struct {
+	verb string
+	bar  *progress_bar.Bar
+}
+
+func NewProgressBars(verb string) *ProgressBars {
+	return &ProgressBars{verb: verb}
+}
+
+func (p *ProgressBars) OnEvent(e Event) {
+	if e.Receiver > 0 {
+		switch e.Type {
+		case FileCompleted:
+			fmt.Printf("\rreceiver %d: %s %s (%s)\n", e.Receiver, p.verb, e.File, tools.ByteCountDecimal(e.Size))
+		case FileSkipped:
+			fmt.Printf("\rreceiver %d: skipped %s\n", e.Receiver, e.File)
+		}
+		return
+	}
+	switch e.Type {
+	case FileStarted:
+		p.bar = nil
+		if e.Size >= 0 {
+			barOpt := &progress_bar.Options{
+				Describe:     e.File,
+				Graph:        ">",
+				IsBytes:      true,
+				ShowPercent:  true,
+				ShowDuration: true,
+			}
+			p.bar = progress_bar.NewBarWithOptions(e.Size, barOpt)
+		}
+	case BytesTransferred:
+		done := e.Size >= 0 && e.Bytes >= e.Size
+		if p.bar != nil {
+			p.bar.Add(e.Bytes)
+			if done {
+				p.bar.Finish()
+				p.bar = nil
+			}
+			return
+		}
+		fmt.Printf("\r%s %s", tools.ByteCountDecimal(e.Bytes), p.verb)
+		if done {
+			fmt.Println()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package files
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// archive formats of FileInfo.Archive
+const (
+	ArchiveZip    = "zip"
+	ArchiveTar    = "tar"
+	ArchiveTarZst = "tar.zst"
+)
+
+// ArchiveReader streams the files and folders as a tar, zstd compressed for tar.zst, the paths
+// in it start with the name of the file or folder. Closing the reader stops the archiving.
+func ArchiveReader(paths []string, format string, filter *Filter) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(writeArchive(pw, paths, format, filter))
+	}()
+	return pr
+}
+
+func writeArchive(w io.Writer, paths []string, format string, filter *Filter) error {
+	switch format {
+	case ArchiveTar:
+		return writeTar(w, paths, filter)
+	case ArchiveTarZst:
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		err = writeTar(encoder, paths, filter)
+		if closeErr := encoder.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return fmt.Errorf("unsupported archive: %s", format)
+}
+
+func writeTar(w io.Writer, paths []string, filter *Filter) error {
+	if filter == nil {
+		filter = &Filter{}
+	}
+	tw := tar.NewWriter(w)
+	for _, pathName := range paths {
+		if err := addToTar(tw, filepath.Clean(pathName), filter); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// addToTar writes the file, or the folder and what's in it, to the tar
+func addToTar(tw *tar.Writer, folder string, filter *Filter) error {
+	walker := newWalker(filter, folder)
+	parent := filepath.Dir(folder)
+	return filepath.Walk(folder, func(pathName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if walker.skip(pathName, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(pathName); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			// devices, sockets and pipes have no data to send
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(parent, pathName)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(pathName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.CopyN(tw, file, info.Size())
+		return err
+	})
+}
+
+// ArchiveName is the name of the archive of the folder
+func ArchiveName(folder string, format string) string {
+	return filepath.Base(folder) + "." + format
+}
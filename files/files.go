@@ -3,7 +3,9 @@ package files
 import (
 	"github.com/duyunis/pdh/tools"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -11,27 +13,53 @@ type Files struct {
 	FilesInfo          []*FileInfo
 	EmptyFolders       []*FileInfo
 	TotalNumberFolders int
+	// FilteredFiles and FilteredFolders were left out by the filter, a folder with everything in it
+	FilteredFiles   int
+	FilteredFolders int
+	// ArchivedSize is the size of the files in the folders streamed as archives
+	ArchivedSize int64
 }
 
 type FileInfo struct {
-	Name         string `json:"Name,omitempty"`
-	FolderRemote string `json:"FolderRemote,omitempty"`
-	FolderSource string `json:"FolderSource,omitempty"`
-	//Hash         []byte `json:"Hash,omitempty"`
-	Size         int64  `json:"Size,omitempty"`
-	ModTime      int64  `json:"ModTime,omitempty"`
-	IsCompressed bool   `json:"IsCompressed,omitempty"`
-	IsEncrypted  bool   `json:"IsEncrypted,omitempty"`
-	Symlink      string `json:"Symlink,omitempty"`
-	Mode         uint32 `json:"Mode,omitempty"`
-	TempFile     bool   `json:"TempFile,omitempty"`
+	Name          string `json:"Name,omitempty"`
+	FolderRemote  string `json:"FolderRemote,omitempty"`
+	FolderSource  string `json:"FolderSource,omitempty"`
+	Hash          []byte `json:"Hash,omitempty"`
+	HashAlgorithm string `json:"HashAlgorithm,omitempty"`
+	IMOHash       []byte `json:"IMOHash,omitempty"`
+	Size          int64  `json:"Size,omitempty"`
+	ModTime       int64  `json:"ModTime,omitempty"`
+	IsCompressed  bool   `json:"IsCompressed,omitempty"`
+	IsEncrypted   bool   `json:"IsEncrypted,omitempty"`
+	Symlink       string `json:"Symlink,omitempty"`
+	Mode          uint32 `json:"Mode,omitempty"`
+	TempFile      bool   `json:"TempFile,omitempty"`
+	// Archive is the format of a folder sent as a single file, the receiver may extract it
+	Archive string `json:"Archive,omitempty"`
+	// Stream has an unknown length, it ends with the EOF of the file data
+	Stream bool `json:"Stream,omitempty"`
+	// Codec may compress the chunks of the file data if IsCompressed is set
+	Codec string `json:"Codec,omitempty"`
 }
 
-func GetFilesInfo(fNames []string, zipFolder bool) (*Files, error) {
+// Stdin is the path of the standard input, sent as a stream
+const Stdin = "-"
+
+// GetFilesInfo collects the files to send, folders are sent as a single file for an archive format,
+// the filter applies to the contents of folders and may be nil, zipped may be nil or is called
+// with each file added to a zip.
+func GetFilesInfo(fNames []string, archive string, filter *Filter, zipped func(zipPath string)) (*Files, error) {
 	// fNames: the relative/absolute paths of files/folders that will be transfered
+	if filter == nil {
+		filter = &Filter{}
+	}
+	if err := filter.check(); err != nil {
+		return nil, err
+	}
+	var filteredFiles, filteredFolders int
+	var archivedSize int64
 	filesInfo := make([]*FileInfo, 0)
 	emptyFolders := make([]*FileInfo, 0)
-	totalNumberFolders := 0
 	var paths []string
 	for _, fName := range fNames {
 		// Support wildcard
@@ -48,6 +76,14 @@ func GetFilesInfo(fNames []string, zipFolder bool) (*Files, error) {
 	}
 
 	for _, path := range paths {
+		if path == Stdin {
+			filesInfo = append(filesInfo, &FileInfo{
+				Name:         "stdin",
+				FolderRemote: "./",
+				Stream:       true,
+			})
+			continue
+		}
 		stat, errStat := os.Lstat(path)
 
 		if errStat != nil {
@@ -60,13 +96,19 @@ func GetFilesInfo(fNames []string, zipFolder bool) (*Files, error) {
 			return nil, errAbs
 		}
 
-		if stat.IsDir() && zipFolder {
+		if stat.IsDir() && archive == ArchiveZip {
 			if path[len(path)-1:] != "/" {
 				path += "/"
 			}
 			path := filepath.Dir(path)
 			dest := filepath.Base(path) + ".zip"
-			_ = tools.ZipDirectory(dest, path)
+			w := newWalker(filter, path)
+			err := tools.ZipDirectory(dest, path, w.skip, zipped)
+			if err != nil {
+				return nil, err
+			}
+			filteredFiles += w.filteredFiles
+			filteredFolders += w.filteredFolders
 			stat, errStat = os.Lstat(dest)
 			if errStat != nil {
 				return nil, errStat
@@ -83,16 +125,25 @@ func GetFilesInfo(fNames []string, zipFolder bool) (*Files, error) {
 				ModTime:      stat.ModTime().UnixMilli(),
 				Mode:         uint32(stat.Mode()),
 				TempFile:     true,
+				Archive:      ArchiveZip,
 			})
 			continue
 		}
 
 		if stat.IsDir() {
+			start, emptyStart := len(filesInfo), len(emptyFolders)
+			w := newWalker(filter, absPath)
 			err := filepath.Walk(absPath,
 				func(pathName string, info os.FileInfo, err error) error {
 					if err != nil {
 						return err
 					}
+					if w.skip(pathName, info) {
+						if info.IsDir() {
+							return filepath.SkipDir
+						}
+						return nil
+					}
 					remoteFolder := strings.TrimPrefix(filepath.Dir(pathName),
 						filepath.Dir(absPath)+string(os.PathSeparator))
 					if !info.IsDir() {
@@ -106,9 +157,9 @@ func GetFilesInfo(fNames []string, zipFolder bool) (*Files, error) {
 							TempFile:     false,
 						})
 					} else {
-						totalNumberFolders++
 						isEmptyFolder, _ := tools.IsEmptyFolder(pathName)
-						if isEmptyFolder {
+						// with includes only the matching files are sent
+						if isEmptyFolder && len(filter.Include) == 0 {
 							emptyFolders = append(emptyFolders, &FileInfo{
 								// Name: info.Name(),
 								FolderRemote: strings.Replace(strings.TrimPrefix(pathName,
@@ -121,7 +172,23 @@ func GetFilesInfo(fNames []string, zipFolder bool) (*Files, error) {
 			if err != nil {
 				return nil, err
 			}
-
+			filteredFiles += w.filteredFiles
+			filteredFolders += w.filteredFolders
+			if archive == ArchiveTar || archive == ArchiveTarZst {
+				// the walk only measured the folder, it's archived while sending
+				for _, fileInfo := range filesInfo[start:] {
+					archivedSize += fileInfo.Size
+				}
+				filesInfo, emptyFolders = filesInfo[:start], emptyFolders[:emptyStart]
+				filesInfo = append(filesInfo, &FileInfo{
+					Name:         ArchiveName(absPath, archive),
+					FolderRemote: "./",
+					FolderSource: absPath,
+					ModTime:      stat.ModTime().UnixMilli(),
+					Archive:      archive,
+					Stream:       true,
+				})
+			}
 		} else {
 			filesInfo = append(filesInfo, &FileInfo{
 				Name:         stat.Name(),
@@ -135,9 +202,33 @@ func GetFilesInfo(fNames []string, zipFolder bool) (*Files, error) {
 		}
 
 	}
-	return &Files{
-		FilesInfo:          filesInfo,
-		EmptyFolders:       emptyFolders,
-		TotalNumberFolders: totalNumberFolders,
-	}, nil
+	fs := &Files{
+		FilesInfo:       filesInfo,
+		EmptyFolders:    emptyFolders,
+		FilteredFiles:   filteredFiles,
+		FilteredFolders: filteredFolders,
+		ArchivedSize:    archivedSize,
+	}
+	fs.TotalNumberFolders = len(fs.Folders())
+	return fs, nil
+}
+
+// Folders returns the folders the other creates to receive the files and empty folders
+func (f *Files) Folders() []string {
+	seen := make(map[string]bool)
+	var folders []string
+	add := func(folder string) {
+		for folder = path.Clean(folder); folder != "." && folder != "/" && !seen[folder]; folder = path.Dir(folder) {
+			seen[folder] = true
+			folders = append(folders, folder)
+		}
+	}
+	for _, fileInfo := range f.FilesInfo {
+		add(fileInfo.FolderRemote)
+	}
+	for _, folder := range f.EmptyFolders {
+		add(folder.FolderRemote)
+	}
+	sort.Strings(folders)
+	return folders
 }
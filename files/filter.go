@@ -0,0 +1,198 @@
+package files
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFiles are read in every folder when the filter respects them, later ones win
+var ignoreFiles = []string{".gitignore", ".pdhignore"}
+
+// Filter decides which files and folders of a sent folder are left out, paths are matched
+// relative to the sent folder. A pattern without a slash matches the name in any folder,
+// one with a slash the path, ** matches any number of folders and a trailing slash only folders.
+type Filter struct {
+	// Exclude are the files and folders left out
+	Exclude []string
+	// Include are the only files sent if set, Exclude wins
+	Include []string
+	// Ignore honors .gitignore and .pdhignore files and leaves out .git folders
+	Ignore bool
+}
+
+// ignoreRule is a line of an ignore file
+type ignoreRule struct {
+	pattern string
+	negate  bool
+}
+
+// walker applies a filter to the walk of a folder and counts what it left out
+type walker struct {
+	filter *Filter
+	root   string
+	// rules of the ignore files by the folder they're in, relative to root
+	rules           map[string][]ignoreRule
+	filteredFiles   int
+	filteredFolders int
+}
+
+func newWalker(filter *Filter, root string) *walker {
+	return &walker{filter: filter, root: root, rules: make(map[string][]ignoreRule)}
+}
+
+// skip reports whether pathName, a path below the root of the walker, is left out
+func (w *walker) skip(pathName string, info os.FileInfo) bool {
+	rel, err := filepath.Rel(w.root, pathName)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel != "." && w.excluded(rel, info.IsDir()) {
+		if info.IsDir() {
+			w.filteredFolders++
+		} else {
+			w.filteredFiles++
+		}
+		return true
+	}
+	if info.IsDir() && w.filter.Ignore {
+		w.loadRules(pathName, rel)
+	}
+	return false
+}
+
+func (w *walker) excluded(rel string, isDir bool) bool {
+	for _, pattern := range w.filter.Exclude {
+		if matchPattern(pattern, rel, isDir) {
+			return true
+		}
+	}
+	if w.filter.Ignore {
+		if isDir && path.Base(rel) == ".git" {
+			return true
+		}
+		ignored := false
+		// the rules of deeper folders come later and win, like the last matching line
+		for dir := parentFolders(rel); len(dir) > 0; dir = dir[1:] {
+			relToDir := strings.TrimPrefix(rel, dir[0]+"/")
+			if dir[0] == "." {
+				relToDir = rel
+			}
+			for _, rule := range w.rules[dir[0]] {
+				if matchPattern(rule.pattern, relToDir, isDir) {
+					ignored = !rule.negate
+				}
+			}
+		}
+		if ignored {
+			return true
+		}
+	}
+	if !isDir && len(w.filter.Include) > 0 {
+		for _, pattern := range w.filter.Include {
+			if matchPattern(pattern, rel, false) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// loadRules reads the ignore files of the folder at pathName
+func (w *walker) loadRules(pathName string, rel string) {
+	for _, name := range ignoreFiles {
+		file, err := os.Open(filepath.Join(pathName, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), " \t\r")
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			rule := ignoreRule{}
+			if strings.HasPrefix(line, "!") {
+				rule.negate = true
+				line = line[1:]
+			}
+			// \# and \! start a pattern with # or !
+			rule.pattern = strings.TrimPrefix(line, `\`)
+			if validPattern(rule.pattern) == nil {
+				w.rules[rel] = append(w.rules[rel], rule)
+			}
+		}
+		_ = file.Close()
+	}
+}
+
+// parentFolders returns the folders containing rel from the root ".", outermost first
+func parentFolders(rel string) []string {
+	folders := []string{"."}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		folders = append(folders, strings.Join(parts[:i], "/"))
+	}
+	return folders
+}
+
+// matchPattern reports whether the slash separated relative path matches the pattern
+func matchPattern(pattern string, rel string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchParts(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(rel, "/"))
+}
+
+func matchParts(pattern []string, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// validPattern returns an error for a malformed pattern
+func validPattern(pattern string) error {
+	for _, part := range strings.Split(pattern, "/") {
+		if _, err := path.Match(part, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// check returns an error for a malformed pattern of the filter
+func (f *Filter) check() error {
+	for _, pattern := range append(append([]string{}, f.Exclude...), f.Include...) {
+		if err := validPattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
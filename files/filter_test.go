@@ -0,0 +1,177 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{"*.log", "a.log", false, true},
+		{"*.log", "deep/er/a.log", false, true},
+		{"*.log", "a.log.txt", false, false},
+		{"a?.txt", "x/ab.txt", false, true},
+		{"[ab].txt", "c.txt", false, false},
+		{"docs/*.md", "docs/a.md", false, true},
+		{"docs/*.md", "x/docs/a.md", false, false},
+		{"docs/*.md", "docs/sub/a.md", false, false},
+		{"/top.txt", "top.txt", false, true},
+		{"/top.txt", "sub/top.txt", false, false},
+		{"top.txt", "sub/top.txt", false, true},
+		{"**/cache", "cache", true, true},
+		{"**/cache", "a/b/cache", true, true},
+		{"docs/**", "docs/a/b.md", false, true},
+		{"docs/**", "other/a.md", false, false},
+		{"a/**/b", "a/b", true, true},
+		{"a/**/b", "a/x/y/b", true, true},
+		{"a/**/b", "a/x/y/c", true, false},
+		{"build/", "build", true, true},
+		{"build/", "sub/build", true, true},
+		{"build/", "build", false, false},
+		{"out/build/", "out/build", true, true},
+		{"out/build/", "out/build", false, false},
+	}
+	for _, c := range cases {
+		if got := matchPattern(c.pattern, c.rel, c.isDir); got != c.want {
+			t.Errorf("matchPattern(%q, %q, dir %v) = %v, want %v", c.pattern, c.rel, c.isDir, got, c.want)
+		}
+	}
+}
+
+// writeTree creates the files at the slash separated paths with their contents below root
+func writeTree(t *testing.T, root string, tree map[string]string) {
+	for name, content := range tree {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// walkFiltered returns the slash separated paths of the files below root the filter lets through
+func walkFiltered(t *testing.T, root string, filter *Filter) []string {
+	if err := filter.check(); err != nil {
+		t.Fatal(err)
+	}
+	w := newWalker(filter, root)
+	var sent []string
+	err := filepath.Walk(root, func(pathName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if w.skip(pathName, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(root, pathName)
+			sent = append(sent, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(sent)
+	return sent
+}
+
+func TestFilterIgnoreFiles(t *testing.T) {
+	root := t.TempDir()
+	tree := map[string]string{
+		".gitignore": "# comment\n*.log\n!keep.log\nbuild/\n!build/keep.txt\n/top.txt\ndocs/**/*.tmp\n\\#hash\nsecret\n",
+		// a later ignore file of the same folder wins
+		".pdhignore":            "!secret\n",
+		"sub/.gitignore":        "!a.log\ndeep.txt\n",
+		"sub/deeper/.gitignore": "!deep.txt\n",
+		".git/config":           "",
+		"a.log":                 "",
+		"keep.log":              "",
+		"top.txt":               "",
+		"sub/top.txt":           "",
+		"build/x.txt":           "",
+		"build/keep.txt":        "",
+		"sub/build":             "",
+		"docs/c.tmp":            "",
+		"docs/a/b/c.tmp":        "",
+		"docs/readme":           "",
+		"#hash":                 "",
+		"secret":                "",
+		"sub/a.log":             "",
+		"sub/b.log":             "",
+		"sub/deep.txt":          "",
+		"sub/deeper/deep.txt":   "",
+	}
+	writeTree(t, root, tree)
+
+	got := walkFiltered(t, root, &Filter{Ignore: true})
+	want := []string{
+		".gitignore",
+		".pdhignore",
+		"docs/readme",
+		"keep.log",
+		"secret",
+		"sub/.gitignore",
+		"sub/a.log",
+		"sub/build",
+		"sub/deeper/.gitignore",
+		"sub/deeper/deep.txt",
+		"sub/top.txt",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+
+	got = walkFiltered(t, root, &Filter{})
+	if len(got) != len(tree) {
+		t.Errorf("sent %d files without Ignore, want all %d: %q", len(got), len(tree), got)
+	}
+}
+
+func TestFilterExcludeInclude(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":    "*.go\n",
+		"main.go":       "",
+		"main_test.go":  "",
+		"x.tmp":         "",
+		"readme.md":     "",
+		"cmd/a/b.txt":   "",
+		"vendor/v.go":   "",
+		"sub/vendor.go": "",
+	})
+
+	got := walkFiltered(t, root, &Filter{
+		Exclude: []string{"*.tmp", "vendor/", "*_test.go"},
+		Include: []string{"*.go", "cmd/**"},
+	})
+	want := []string{"cmd/a/b.txt", "main.go", "sub/vendor.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+func TestFilterCheck(t *testing.T) {
+	if err := (&Filter{Exclude: []string{"a/[b"}}).check(); err == nil {
+		t.Error("check accepted a malformed exclude pattern")
+	}
+	if err := (&Filter{Include: []string{"[]"}}).check(); err == nil {
+		t.Error("check accepted a malformed include pattern")
+	}
+	if err := (&Filter{Exclude: []string{"**/*.log", "build/"}, Include: []string{"[ab]*"}}).check(); err != nil {
+		t.Errorf("check refused valid patterns: %v", err)
+	}
+}
@@ -3,22 +3,33 @@ module github.com/duyunis/pdh
 go 1.19
 
 require (
-	github.com/cespare/xxhash/v2 v2.1.1
+	github.com/cespare/xxhash/v2 v2.1.2
 	github.com/duyunis/discovery v1.0.4
 	github.com/duyunis/progress_bar v0.1.3
 	github.com/kalafut/imohash v1.0.2
+	github.com/klauspost/compress v1.15.15
+	github.com/pierrec/lz4/v4 v4.1.17
+	github.com/prometheus/client_golang v1.14.0
+	github.com/schollz/pake/v3 v3.0.5
 	github.com/spf13/cobra v1.6.0
+	golang.org/x/crypto v0.3.0
 	google.golang.org/grpc v1.50.1
 	google.golang.org/protobuf v1.28.1
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/inconshreveable/mousetrap v1.0.1 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.37.0 // indirect
+	github.com/prometheus/procfs v0.8.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/tscholl2/siec v0.0.0-20210707234609-9bdfc483d499 // indirect
 	github.com/twmb/murmur3 v1.1.5 // indirect
 	golang.org/x/net v0.2.0 // indirect
 	golang.org/x/sys v0.2.0 // indirect
 	golang.org/x/text v0.4.0 // indirect
-	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
+	google.golang.org/genproto v0.0.0-20200825200019-8632dd797987 // indirect
 )
@@ -1,9 +1,11 @@
 package message
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/duyunis/pdh/crypt"
 	"github.com/duyunis/pdh/files"
 	"github.com/duyunis/pdh/proto"
 )
@@ -18,6 +20,15 @@ const (
 	JSONProtocol Protocol = "json"
 )
 
+const (
+	// rawFrameVersion starts every raw frame, file data is only sent in raw frames
+	rawFrameVersion byte = 1
+	// rawFrameHeaderSize is version, flags and position
+	rawFrameHeaderSize      = 10
+	rawFlagEOF         byte = 1 << 0
+	rawFlagCompressed  byte = 1 << 1
+)
+
 // Message is the interface of a message to send over the wire
 type Message interface {
 	Bytes(protocol Protocol) ([]byte, error)
@@ -34,10 +45,30 @@ func (c *ShareCodePayload) Bytes(protocol Protocol) ([]byte, error) {
 	return nil, nil
 }
 
+type GetFileStatPayload struct {
+	// Codecs are the compression codecs the receiver can decompress
+	Codecs []string `json:"Codecs,omitempty"`
+}
+
+func (g *GetFileStatPayload) Bytes(protocol Protocol) ([]byte, error) {
+	if protocol == JSONProtocol {
+		return json.Marshal(g)
+	}
+	return nil, nil
+}
+
 type FileStatPayload struct {
 	FilesSize    int64 `json:"FilesSize,omitempty"`
 	FilesNumber  int64 `json:"FilesNumber,omitempty"`
 	FolderNumber int64 `json:"FolderNumber,omitempty"`
+	Streams      int64 `json:"Streams,omitempty"`
+	Stream       bool  `json:"Stream,omitempty"`
+	// CertFingerprint pins the ephemeral certificate of the local network server
+	CertFingerprint []byte `json:"CertFingerprint,omitempty"`
+	// EmptyFolders are created by the receiver, no file is sent for them
+	EmptyFolders []string `json:"EmptyFolders,omitempty"`
+	// Text is sent instead of files
+	Text string `json:"Text,omitempty"`
 }
 
 func (f *FileStatPayload) Bytes(protocol Protocol) ([]byte, error) {
@@ -62,11 +93,114 @@ type FileDataPayload struct {
 	Data     []byte
 	Position int64
 	EOF      bool
+	// Compressed is set when Data is compressed with the codec of the file
+	Compressed bool `json:"Compressed,omitempty"`
 }
 
 func (f *FileDataPayload) Bytes(protocol Protocol) ([]byte, error) {
+	if protocol == RawProtocol {
+		frame := make([]byte, rawFrameHeaderSize+len(f.Data))
+		frame[0] = rawFrameVersion
+		if f.EOF {
+			frame[1] |= rawFlagEOF
+		}
+		if f.Compressed {
+			frame[1] |= rawFlagCompressed
+		}
+		binary.BigEndian.PutUint64(frame[2:rawFrameHeaderSize], uint64(f.Position))
+		copy(frame[rawFrameHeaderSize:], f.Data)
+		return frame, nil
+	}
+	return nil, nil
+}
+
+func parseRawFileData(frame []byte) (*FileDataPayload, error) {
+	if len(frame) < rawFrameHeaderSize {
+		return nil, errors.New("raw frame is too short")
+	}
+	if frame[0] != rawFrameVersion {
+		return nil, errors.New(fmt.Sprintf("unknown raw frame version: [%d]", frame[0]))
+	}
+	if frame[1]&^(rawFlagEOF|rawFlagCompressed) != 0 {
+		return nil, errors.New(fmt.Sprintf("unknown raw frame flags: [%d]", frame[1]))
+	}
+	position := int64(binary.BigEndian.Uint64(frame[2:rawFrameHeaderSize]))
+	if position < 0 {
+		return nil, errors.New("raw frame position is negative")
+	}
+	return &FileDataPayload{
+		Data:       frame[rawFrameHeaderSize:],
+		Position:   position,
+		EOF:        frame[1]&rawFlagEOF != 0,
+		Compressed: frame[1]&rawFlagCompressed != 0,
+	}, nil
+}
+
+type KeyExchangePayload struct {
+	Pake []byte `json:"Pake,omitempty"`
+	Salt []byte `json:"Salt,omitempty"`
+}
+
+func (k *KeyExchangePayload) Bytes(protocol Protocol) ([]byte, error) {
 	if protocol == JSONProtocol {
-		return json.Marshal(f)
+		return json.Marshal(k)
+	}
+	return nil, nil
+}
+
+type AgreeReceivePayload struct {
+	Streams int64 `json:"Streams,omitempty"`
+}
+
+func (a *AgreeReceivePayload) Bytes(protocol Protocol) ([]byte, error) {
+	if protocol == JSONProtocol {
+		return json.Marshal(a)
+	}
+	return nil, nil
+}
+
+type DataStreamPayload struct {
+	Index int64 `json:"Index,omitempty"`
+}
+
+func (d *DataStreamPayload) Bytes(protocol Protocol) ([]byte, error) {
+	if protocol == JSONProtocol {
+		return json.Marshal(d)
+	}
+	return nil, nil
+}
+
+type ResumePayload struct {
+	Position int64 `json:"Position,omitempty"`
+}
+
+func (r *ResumePayload) Bytes(protocol Protocol) ([]byte, error) {
+	if protocol == JSONProtocol {
+		return json.Marshal(r)
+	}
+	return nil, nil
+}
+
+// StorePayload describes a transfer stored on the relay, the relay keeps it as it is
+type StorePayload struct {
+	Channel string
+	// Salt derives the key of the transfer from the share code
+	Salt []byte `json:"Salt,omitempty"`
+	// Manifest is the file stat, encrypted like the file data
+	Manifest []byte `json:"Manifest,omitempty"`
+	// MaxAge is the seconds the sender asks the relay to keep the transfer
+	MaxAge int64 `json:"MaxAge,omitempty"`
+	// Expires is when the relay deletes the transfer, in unix seconds
+	Expires      int64 `json:"Expires,omitempty"`
+	MaxDownloads int64 `json:"MaxDownloads,omitempty"`
+	Downloads    int64 `json:"Downloads,omitempty"`
+	// Size of the stored data
+	Size int64 `json:"Size,omitempty"`
+}
+
+func (s *StorePayload) Bytes(protocol Protocol) ([]byte, error) {
+	if protocol == JSONProtocol {
+		return json.Marshal(s)
 	}
 	return nil, nil
 }
@@ -77,12 +211,21 @@ func ParseMessagePayload(msg *proto.Message) (Message, error) {
 	}
 	payload := msg.Payload
 	switch msg.MessageType {
-	case proto.MessageType_CreateChannel, proto.MessageType_JoinChannel:
+	case proto.MessageType_CreateChannel, proto.MessageType_JoinChannel, proto.MessageType_Fetch:
 		shareCode := ""
 		if payload != nil {
 			shareCode = string(payload)
 		}
 		return &ShareCodePayload{ShareCode: shareCode}, nil
+	case proto.MessageType_GetFileStat:
+		var gs GetFileStatPayload
+		if payload != nil {
+			err := json.Unmarshal(payload, &gs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &gs, nil
 	case proto.MessageType_FileStat:
 		if payload != nil {
 			var fs FileStatPayload
@@ -103,12 +246,52 @@ func ParseMessagePayload(msg *proto.Message) (Message, error) {
 		}
 	case proto.MessageType_FileData:
 		if payload != nil {
-			var fd FileDataPayload
-			err := json.Unmarshal(payload, &fd)
+			return parseRawFileData(payload)
+		}
+	case proto.MessageType_KeyExchange:
+		if payload != nil {
+			var ke KeyExchangePayload
+			err := json.Unmarshal(payload, &ke)
+			if err != nil {
+				return nil, err
+			}
+			return &ke, nil
+		}
+	case proto.MessageType_AgreeReceive:
+		var ar AgreeReceivePayload
+		if payload != nil {
+			err := json.Unmarshal(payload, &ar)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &ar, nil
+	case proto.MessageType_DataStream:
+		if payload != nil {
+			var ds DataStreamPayload
+			err := json.Unmarshal(payload, &ds)
+			if err != nil {
+				return nil, err
+			}
+			return &ds, nil
+		}
+	case proto.MessageType_ResumeReceive:
+		if payload != nil {
+			var rp ResumePayload
+			err := json.Unmarshal(payload, &rp)
 			if err != nil {
 				return nil, err
 			}
-			return &fd, nil
+			return &rp, nil
+		}
+	case proto.MessageType_Store, proto.MessageType_StoreSuccess, proto.MessageType_FetchSuccess:
+		if payload != nil {
+			var sp StorePayload
+			err := json.Unmarshal(payload, &sp)
+			if err != nil {
+				return nil, err
+			}
+			return &sp, nil
 		}
 	default:
 		return nil, errors.New(fmt.Sprintf("unknown message type: [%s]", msg.MessageType.String()))
@@ -116,9 +299,41 @@ func ParseMessagePayload(msg *proto.Message) (Message, error) {
 	return nil, nil
 }
 
+// ParseEncryptedMessagePayload decrypts the payload with key before parsing it,
+// a message without payload is an error since the other always encrypts one.
+func ParseEncryptedMessagePayload(msg *proto.Message, key []byte) (Message, error) {
+	if msg == nil {
+		return nil, errors.New("message is nil")
+	}
+	if len(msg.Payload) == 0 {
+		return nil, errors.New(fmt.Sprintf("missing encrypted payload of [%s]", msg.MessageType.String()))
+	}
+	payload, err := crypt.Decrypt(msg.Payload, key)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := ParseMessagePayload(NewMessage(msg.MessageType, payload))
+	if err == nil && pm == nil {
+		err = errors.New(fmt.Sprintf("empty payload of [%s]", msg.MessageType.String()))
+	}
+	return pm, err
+}
+
 func NewMessage(messageType proto.MessageType, payload []byte) *proto.Message {
 	return &proto.Message{
 		MessageType: messageType,
 		Payload:     payload,
 	}
 }
+
+// NewEncryptedMessage returns a message whose payload is encrypted with key
+func NewEncryptedMessage(messageType proto.MessageType, payload []byte, key []byte) (*proto.Message, error) {
+	if payload == nil {
+		return NewMessage(messageType, nil), nil
+	}
+	encrypted, err := crypt.Encrypt(payload, key)
+	if err != nil {
+		return nil, err
+	}
+	return NewMessage(messageType, encrypted), nil
+}
@@ -0,0 +1,68 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseRawFileData(t *testing.T) {
+	header := func(version, flags byte, position uint64) []byte {
+		frame := []byte{version, flags, 0, 0, 0, 0, 0, 0, 0, 0}
+		for i := 0; i < 8; i++ {
+			frame[9-i] = byte(position >> (8 * i))
+		}
+		return frame
+	}
+
+	cases := []struct {
+		name  string
+		frame []byte
+		want  *FileDataPayload
+	}{
+		{"data", append(header(rawFrameVersion, 0, 3), 'a', 'b', 'c'), &FileDataPayload{Data: []byte("abc"), Position: 3}},
+		{"empty last chunk", header(rawFrameVersion, rawFlagEOF, 7), &FileDataPayload{Data: []byte{}, Position: 7, EOF: true}},
+		{"compressed", append(header(rawFrameVersion, rawFlagCompressed, 1), 'x'), &FileDataPayload{Data: []byte("x"), Position: 1, Compressed: true}},
+		{"both flags", append(header(rawFrameVersion, rawFlagEOF|rawFlagCompressed, 1), 'x'), &FileDataPayload{Data: []byte("x"), Position: 1, EOF: true, Compressed: true}},
+		{"empty frame", nil, nil},
+		{"short frame", header(rawFrameVersion, 0, 0)[:rawFrameHeaderSize-1], nil},
+		{"unknown version", header(2, 0, 0), nil},
+		{"json payload", []byte(`{"Position":1}`), nil},
+		{"unknown flag", header(rawFrameVersion, 1<<2, 0), nil},
+		{"negative position", header(rawFrameVersion, 0, 1<<63), nil},
+	}
+	for _, c := range cases {
+		got, err := parseRawFileData(c.frame)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("%s: parseRawFileData = %+v, want an error", c.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseRawFileData error: %s", c.name, err)
+			continue
+		}
+		if !bytes.Equal(got.Data, c.want.Data) || got.Position != c.want.Position || got.EOF != c.want.EOF || got.Compressed != c.want.Compressed {
+			t.Errorf("%s: parseRawFileData = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRawFileDataRoundTrip(t *testing.T) {
+	for _, want := range []*FileDataPayload{
+		{Data: []byte("chunk"), Position: 1 << 40, EOF: true},
+		{Data: []byte{}, Position: 0, Compressed: true},
+	} {
+		frame, err := want.Bytes(RawProtocol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseRawFileData(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data, want.Data) || got.Position != want.Position || got.EOF != want.EOF || got.Compressed != want.Compressed {
+			t.Errorf("round trip = %+v, want %+v", got, want)
+		}
+	}
+}
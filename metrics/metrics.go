@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// Directions of the data piped through a channel
+const (
+	OwnerToVisitor = "owner_to_visitor"
+	VisitorToOwner = "visitor_to_owner"
+)
+
+// Results of joining a channel
+const (
+	JoinSuccess      = "success"
+	JoinFull         = "full"
+	JoinNotFound     = "not_found"
+	JoinFailed       = "failed"
+	JoinUnauthorized = "unauthorized"
+)
+
+// Reasons the limits of the relay rejected a request
+const (
+	RejectedTooManyChannels = "too_many_channels"
+	RejectedRelayFull       = "relay_full"
+	RejectedTooManyJoins    = "too_many_joins"
+	RejectedQuota           = "quota_exceeded"
+)
+
+var (
+	ChannelsOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pdh_relay_channels_open",
+		Help: "Number of channels on the relay.",
+	})
+	ChannelsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pdh_relay_channels_created_total",
+		Help: "Number of channels created.",
+	})
+	ChannelLifetime = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pdh_relay_channel_lifetime_seconds",
+		Help:    "Time from creating a channel to removing it.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	})
+	Joins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pdh_relay_joins_total",
+		Help: "Number of channel joins by result.",
+	}, []string{"result"})
+	Rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pdh_relay_rejected_total",
+		Help: "Number of channels, joins and transfers the limits of the relay rejected by reason.",
+	}, []string{"reason"})
+	PipeBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pdh_relay_pipe_bytes_total",
+		Help: "Payload bytes piped between the owner and the visitor of channels.",
+	}, []string{"direction"})
+	PipeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pdh_relay_pipe_errors_total",
+		Help: "Number of messages that could not be piped to the other side.",
+	})
+	StoredTransfers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pdh_relay_stored_transfers",
+		Help: "Number of transfers stored on the relay.",
+	})
+	StoredBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pdh_relay_stored_bytes",
+		Help: "Bytes of the transfers stored on the relay and of their uploads.",
+	})
+	GrpcStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pdh_grpc_streams_active",
+		Help: "Number of open gRPC streams.",
+	})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		ChannelsOpen,
+		ChannelsCreated,
+		ChannelLifetime,
+		Joins,
+		Rejected,
+		PipeBytes,
+		PipeErrors,
+		StoredTransfers,
+		StoredBytes,
+		GrpcStreams,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Serve serves the metrics at /metrics of address
+func Serve(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return http.ListenAndServe(address, mux)
+}
@@ -1,16 +1,74 @@
 package options
 
+import (
+	"crypto/tls"
+	"time"
+)
+
 type RelayOptions struct {
 	RelayHost string
 	RelayPort string
+	TLSCert   string
+	TLSKey    string
+	AuthFile  string
+	AuthJoin  bool
+	// MetricsAddress serves the prometheus metrics, empty disables them
+	MetricsAddress string
+	// Output of the command, text or json
+	Output string
+	// StoreDir keeps the transfers senders store on the relay, empty disables storing
+	StoreDir string
+	// StoreQuota bounds the bytes of all stored transfers
+	StoreQuota int64
+	// StoreMaxAge is the longest a stored transfer is kept
+	StoreMaxAge time.Duration
+	// MaxChannels open at once, 0 is unlimited
+	MaxChannels int
+	// MaxChannelsPerIP open at once by one client address, 0 is unlimited
+	MaxChannelsPerIP int
+	// MaxChannelBytes forwarded by a channel and its data channels, 0 is unlimited
+	MaxChannelBytes int64
+	// MaxChannelRate is the bytes per second a channel and its data channels forward, 0 is unlimited
+	MaxChannelRate int64
+	// MaxJoinsPerMinute of one client address, fetching a stored transfer counts too, 0 is unlimited
+	MaxJoinsPerMinute int
 }
 
 type SenderOptions struct {
-	ShareCode    string
-	Relay        string
-	Zip          bool
-	LocalNetwork bool
-	LocalPort    string
+	ShareCode     string
+	Relay         string
+	Zip           bool
+	LocalNetwork  bool
+	LocalPort     string
+	HashAlgorithm string
+	Streams       int
+	Text          string
+	CodeLength    int
+	Phrase        bool
+	RelayCA       string
+	RelayTLS      bool
+	RelayToken    string
+	// Output of the command, text or json
+	Output string
+	// Exclude and Include filter the contents of sent folders, see files.Filter
+	Exclude []string
+	Include []string
+	// RespectGitignore leaves out what .gitignore and .pdhignore files ignore
+	RespectGitignore bool
+	// Archive streams each folder as a single file, tar or tar.zst
+	Archive string
+	// Compression is the codec of the file data, none, flate, zstd or lz4, empty is zstd
+	Compression string
+	// CompressionLevel of the codec, 0 is its default
+	CompressionLevel int
+	// Receivers is how many receivers can receive with the share code, 0 is one
+	Receivers int
+	// Store uploads the transfer to the relay, the receiver downloads it later
+	Store bool
+	// Expire is how long the relay keeps a stored transfer, 0 is as long as the relay allows
+	Expire time.Duration
+	// MaxDownloads of a stored transfer, 0 is one
+	MaxDownloads int
 }
 
 type ReceiverOptions struct {
@@ -20,9 +78,35 @@ type ReceiverOptions struct {
 	Zip          bool
 	LocalNetwork bool
 	LocalPort    string
+	Quarantine   bool
+	SkipExisting bool
+	// UnsafeSymlinks also creates symlinks that point outside the out path, they are refused by default
+	UnsafeSymlinks bool
+	RelayCA        string
+	RelayTLS       bool
+	RelayToken     string
+	// Output of the command, text or json
+	Output string
+	// Yes accepts the transfer without asking, and existing files are overwritten unless OnConflict says otherwise
+	Yes bool
+	// OnConflict is what happens to an existing file: ask, overwrite, skip, rename, newer or fail
+	OnConflict string
+	// MaxSize refuses transfers of more bytes, 0 is no limit
+	MaxSize int64
+	// Extract unpacks received archives into the receive path instead of keeping them
+	Extract bool
+}
+
+type GrpcClientOptions struct {
+	// TLSConfig is nil for a plaintext connection
+	TLSConfig *tls.Config
+	// Token is sent to the relay in the metadata of the stream
+	Token string
 }
 
 type GrpcServerOptions struct {
 	Address string
 	Ports   string
+	// TLSConfig is nil for a plaintext server
+	TLSConfig *tls.Config
 }
@@ -48,6 +48,22 @@ const (
 	MessageType_SendFileFinish       MessageType = 22
 	MessageType_Interrupt            MessageType = 23
 	MessageType_LocalNetworkMode     MessageType = 24
+	MessageType_KeyExchange          MessageType = 25
+	MessageType_ResumeReceive        MessageType = 26
+	MessageType_DataStream           MessageType = 27
+	MessageType_Unauthorized         MessageType = 28
+	MessageType_Store                MessageType = 29
+	MessageType_StoreReady           MessageType = 30
+	MessageType_StoreData            MessageType = 31
+	MessageType_StoreFinish          MessageType = 32
+	MessageType_StoreSuccess         MessageType = 33
+	MessageType_StoreFailed          MessageType = 34
+	MessageType_Fetch                MessageType = 35
+	MessageType_FetchSuccess         MessageType = 36
+	MessageType_TooManyChannels      MessageType = 37
+	MessageType_RelayFull            MessageType = 38
+	MessageType_TooManyJoins         MessageType = 39
+	MessageType_ChannelQuotaExceeded MessageType = 40
 )
 
 // Enum value maps for MessageType.
@@ -78,6 +94,22 @@ var (
 		22: "SendFileFinish",
 		23: "Interrupt",
 		24: "LocalNetworkMode",
+		25: "KeyExchange",
+		26: "ResumeReceive",
+		27: "DataStream",
+		28: "Unauthorized",
+		29: "Store",
+		30: "StoreReady",
+		31: "StoreData",
+		32: "StoreFinish",
+		33: "StoreSuccess",
+		34: "StoreFailed",
+		35: "Fetch",
+		36: "FetchSuccess",
+		37: "TooManyChannels",
+		38: "RelayFull",
+		39: "TooManyJoins",
+		40: "ChannelQuotaExceeded",
 	}
 	MessageType_value = map[string]int32{
 		"Ping":                 0,
@@ -105,6 +137,22 @@ var (
 		"SendFileFinish":       22,
 		"Interrupt":            23,
 		"LocalNetworkMode":     24,
+		"KeyExchange":          25,
+		"ResumeReceive":        26,
+		"DataStream":           27,
+		"Unauthorized":         28,
+		"Store":                29,
+		"StoreReady":           30,
+		"StoreData":            31,
+		"StoreFinish":          32,
+		"StoreSuccess":         33,
+		"StoreFailed":          34,
+		"Fetch":                35,
+		"FetchSuccess":         36,
+		"TooManyChannels":      37,
+		"RelayFull":            38,
+		"TooManyJoins":         39,
+		"ChannelQuotaExceeded": 40,
 	}
 )
 
@@ -142,6 +190,8 @@ type Message struct {
 
 	MessageType MessageType `protobuf:"varint,1,opt,name=MessageType,proto3,enum=MessageType" json:"MessageType,omitempty"`
 	Payload     []byte      `protobuf:"bytes,2,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	Peer        int64       `protobuf:"varint,3,opt,name=Peer,proto3" json:"Peer,omitempty"`
+	Receivers   int64       `protobuf:"varint,4,opt,name=Receivers,proto3" json:"Receivers,omitempty"`
 }
 
 func (x *Message) Reset() {
@@ -190,48 +240,83 @@ func (x *Message) GetPayload() []byte {
 	return nil
 }
 
+func (x *Message) GetPeer() int64 {
+	if x != nil {
+		return x.Peer
+	}
+	return 0
+}
+
+func (x *Message) GetReceivers() int64 {
+	if x != nil {
+		return x.Receivers
+	}
+	return 0
+}
+
 var File_proto_pdh_proto protoreflect.FileDescriptor
 
 var file_proto_pdh_proto_rawDesc = []byte{
 	0x0a, 0x0f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x70, 0x64, 0x68, 0x2e, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x22, 0x53, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x2e, 0x0a, 0x0b,
-	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x0e, 0x32, 0x0c, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x52,
-	0x0b, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x18, 0x0a, 0x07,
-	0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x50,
-	0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x2a, 0xbe, 0x03, 0x0a, 0x0b, 0x4d, 0x65, 0x73, 0x73, 0x61,
-	0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x08, 0x0a, 0x04, 0x50, 0x69, 0x6e, 0x67, 0x10, 0x00,
-	0x12, 0x08, 0x0a, 0x04, 0x50, 0x6f, 0x6e, 0x67, 0x10, 0x01, 0x12, 0x0b, 0x0a, 0x07, 0x53, 0x75,
-	0x63, 0x63, 0x65, 0x73, 0x73, 0x10, 0x02, 0x12, 0x0a, 0x0a, 0x06, 0x46, 0x61, 0x69, 0x6c, 0x65,
-	0x64, 0x10, 0x03, 0x12, 0x11, 0x0a, 0x0d, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x43, 0x68, 0x61,
-	0x6e, 0x6e, 0x65, 0x6c, 0x10, 0x04, 0x12, 0x18, 0x0a, 0x14, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65,
-	0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x10, 0x05,
-	0x12, 0x17, 0x0a, 0x13, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65,
-	0x6c, 0x46, 0x61, 0x69, 0x6c, 0x65, 0x64, 0x10, 0x06, 0x12, 0x0f, 0x0a, 0x0b, 0x4a, 0x6f, 0x69,
-	0x6e, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x10, 0x07, 0x12, 0x16, 0x0a, 0x12, 0x4a, 0x6f,
-	0x69, 0x6e, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73,
-	0x10, 0x08, 0x12, 0x15, 0x0a, 0x11, 0x4a, 0x6f, 0x69, 0x6e, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65,
-	0x6c, 0x46, 0x61, 0x69, 0x6c, 0x65, 0x64, 0x10, 0x09, 0x12, 0x0f, 0x0a, 0x0b, 0x43, 0x68, 0x61,
-	0x6e, 0x6e, 0x65, 0x6c, 0x46, 0x75, 0x6c, 0x6c, 0x10, 0x0a, 0x12, 0x13, 0x0a, 0x0f, 0x43, 0x68,
-	0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x4e, 0x6f, 0x74, 0x46, 0x6f, 0x75, 0x6e, 0x64, 0x10, 0x0b, 0x12,
-	0x0f, 0x0a, 0x0b, 0x47, 0x65, 0x74, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x74, 0x61, 0x74, 0x10, 0x0c,
-	0x12, 0x0c, 0x0a, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x74, 0x61, 0x74, 0x10, 0x0d, 0x12, 0x0c,
-	0x0a, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x10, 0x0e, 0x12, 0x10, 0x0a, 0x0c,
-	0x41, 0x67, 0x72, 0x65, 0x65, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x10, 0x0f, 0x12, 0x11,
-	0x0a, 0x0d, 0x52, 0x65, 0x66, 0x75, 0x73, 0x65, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x10,
-	0x10, 0x12, 0x0a, 0x0a, 0x06, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x10, 0x11, 0x12, 0x0c, 0x0a,
-	0x08, 0x53, 0x6b, 0x69, 0x70, 0x46, 0x69, 0x6c, 0x65, 0x10, 0x12, 0x12, 0x0c, 0x0a, 0x08, 0x46,
-	0x69, 0x6c, 0x65, 0x44, 0x61, 0x74, 0x61, 0x10, 0x13, 0x12, 0x0e, 0x0a, 0x0a, 0x46, 0x69, 0x6c,
-	0x65, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68, 0x10, 0x14, 0x12, 0x13, 0x0a, 0x0f, 0x52, 0x65, 0x61,
-	0x64, 0x79, 0x46, 0x6f, 0x72, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x10, 0x15, 0x12, 0x12,
-	0x0a, 0x0e, 0x53, 0x65, 0x6e, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68,
-	0x10, 0x16, 0x12, 0x0d, 0x0a, 0x09, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x72, 0x75, 0x70, 0x74, 0x10,
-	0x17, 0x12, 0x14, 0x0a, 0x10, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72,
-	0x6b, 0x4d, 0x6f, 0x64, 0x65, 0x10, 0x18, 0x32, 0x32, 0x0a, 0x0a, 0x50, 0x64, 0x68, 0x53, 0x65,
-	0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x24, 0x0a, 0x08, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69,
-	0x74, 0x12, 0x08, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a, 0x08, 0x2e, 0x4d, 0x65,
-	0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x00, 0x28, 0x01, 0x30, 0x01, 0x42, 0x0b, 0x5a, 0x09, 0x70,
-	0x64, 0x68, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x6f, 0x22, 0x85, 0x01, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x2e, 0x0a,
+	0x0b, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0e, 0x32, 0x0c, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65,
+	0x52, 0x0b, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x18, 0x0a,
+	0x07, 0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07,
+	0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x50, 0x65, 0x65, 0x72, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x50, 0x65, 0x65, 0x72, 0x12, 0x1c, 0x0a, 0x09, 0x52,
+	0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x72, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
+	0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x72, 0x73, 0x2a, 0xcf, 0x05, 0x0a, 0x0b, 0x4d, 0x65,
+	0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x08, 0x0a, 0x04, 0x50, 0x69, 0x6e,
+	0x67, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x50, 0x6f, 0x6e, 0x67, 0x10, 0x01, 0x12, 0x0b, 0x0a,
+	0x07, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x10, 0x02, 0x12, 0x0a, 0x0a, 0x06, 0x46, 0x61,
+	0x69, 0x6c, 0x65, 0x64, 0x10, 0x03, 0x12, 0x11, 0x0a, 0x0d, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65,
+	0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x10, 0x04, 0x12, 0x18, 0x0a, 0x14, 0x43, 0x72, 0x65,
+	0x61, 0x74, 0x65, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73,
+	0x73, 0x10, 0x05, 0x12, 0x17, 0x0a, 0x13, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x43, 0x68, 0x61,
+	0x6e, 0x6e, 0x65, 0x6c, 0x46, 0x61, 0x69, 0x6c, 0x65, 0x64, 0x10, 0x06, 0x12, 0x0f, 0x0a, 0x0b,
+	0x4a, 0x6f, 0x69, 0x6e, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x10, 0x07, 0x12, 0x16, 0x0a,
+	0x12, 0x4a, 0x6f, 0x69, 0x6e, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x53, 0x75, 0x63, 0x63,
+	0x65, 0x73, 0x73, 0x10, 0x08, 0x12, 0x15, 0x0a, 0x11, 0x4a, 0x6f, 0x69, 0x6e, 0x43, 0x68, 0x61,
+	0x6e, 0x6e, 0x65, 0x6c, 0x46, 0x61, 0x69, 0x6c, 0x65, 0x64, 0x10, 0x09, 0x12, 0x0f, 0x0a, 0x0b,
+	0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x46, 0x75, 0x6c, 0x6c, 0x10, 0x0a, 0x12, 0x13, 0x0a,
+	0x0f, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x4e, 0x6f, 0x74, 0x46, 0x6f, 0x75, 0x6e, 0x64,
+	0x10, 0x0b, 0x12, 0x0f, 0x0a, 0x0b, 0x47, 0x65, 0x74, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x74, 0x61,
+	0x74, 0x10, 0x0c, 0x12, 0x0c, 0x0a, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x74, 0x61, 0x74, 0x10,
+	0x0d, 0x12, 0x0c, 0x0a, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x10, 0x0e, 0x12,
+	0x10, 0x0a, 0x0c, 0x41, 0x67, 0x72, 0x65, 0x65, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x10,
+	0x0f, 0x12, 0x11, 0x0a, 0x0d, 0x52, 0x65, 0x66, 0x75, 0x73, 0x65, 0x52, 0x65, 0x63, 0x65, 0x69,
+	0x76, 0x65, 0x10, 0x10, 0x12, 0x0a, 0x0a, 0x06, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x10, 0x11,
+	0x12, 0x0c, 0x0a, 0x08, 0x53, 0x6b, 0x69, 0x70, 0x46, 0x69, 0x6c, 0x65, 0x10, 0x12, 0x12, 0x0c,
+	0x0a, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x44, 0x61, 0x74, 0x61, 0x10, 0x13, 0x12, 0x0e, 0x0a, 0x0a,
+	0x46, 0x69, 0x6c, 0x65, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68, 0x10, 0x14, 0x12, 0x13, 0x0a, 0x0f,
+	0x52, 0x65, 0x61, 0x64, 0x79, 0x46, 0x6f, 0x72, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x10,
+	0x15, 0x12, 0x12, 0x0a, 0x0e, 0x53, 0x65, 0x6e, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x46, 0x69, 0x6e,
+	0x69, 0x73, 0x68, 0x10, 0x16, 0x12, 0x0d, 0x0a, 0x09, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x72, 0x75,
+	0x70, 0x74, 0x10, 0x17, 0x12, 0x14, 0x0a, 0x10, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x4e, 0x65, 0x74,
+	0x77, 0x6f, 0x72, 0x6b, 0x4d, 0x6f, 0x64, 0x65, 0x10, 0x18, 0x12, 0x0f, 0x0a, 0x0b, 0x4b, 0x65,
+	0x79, 0x45, 0x78, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x10, 0x19, 0x12, 0x11, 0x0a, 0x0d, 0x52,
+	0x65, 0x73, 0x75, 0x6d, 0x65, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x10, 0x1a, 0x12, 0x0e,
+	0x0a, 0x0a, 0x44, 0x61, 0x74, 0x61, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x10, 0x1b, 0x12, 0x10,
+	0x0a, 0x0c, 0x55, 0x6e, 0x61, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x64, 0x10, 0x1c,
+	0x12, 0x09, 0x0a, 0x05, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x10, 0x1d, 0x12, 0x0e, 0x0a, 0x0a, 0x53,
+	0x74, 0x6f, 0x72, 0x65, 0x52, 0x65, 0x61, 0x64, 0x79, 0x10, 0x1e, 0x12, 0x0d, 0x0a, 0x09, 0x53,
+	0x74, 0x6f, 0x72, 0x65, 0x44, 0x61, 0x74, 0x61, 0x10, 0x1f, 0x12, 0x0f, 0x0a, 0x0b, 0x53, 0x74,
+	0x6f, 0x72, 0x65, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68, 0x10, 0x20, 0x12, 0x10, 0x0a, 0x0c, 0x53,
+	0x74, 0x6f, 0x72, 0x65, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x10, 0x21, 0x12, 0x0f, 0x0a,
+	0x0b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x46, 0x61, 0x69, 0x6c, 0x65, 0x64, 0x10, 0x22, 0x12, 0x09,
+	0x0a, 0x05, 0x46, 0x65, 0x74, 0x63, 0x68, 0x10, 0x23, 0x12, 0x10, 0x0a, 0x0c, 0x46, 0x65, 0x74,
+	0x63, 0x68, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x10, 0x24, 0x12, 0x13, 0x0a, 0x0f, 0x54,
+	0x6f, 0x6f, 0x4d, 0x61, 0x6e, 0x79, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x73, 0x10, 0x25,
+	0x12, 0x0d, 0x0a, 0x09, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x46, 0x75, 0x6c, 0x6c, 0x10, 0x26, 0x12,
+	0x10, 0x0a, 0x0c, 0x54, 0x6f, 0x6f, 0x4d, 0x61, 0x6e, 0x79, 0x4a, 0x6f, 0x69, 0x6e, 0x73, 0x10,
+	0x27, 0x12, 0x18, 0x0a, 0x14, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x51, 0x75, 0x6f, 0x74,
+	0x61, 0x45, 0x78, 0x63, 0x65, 0x65, 0x64, 0x65, 0x64, 0x10, 0x28, 0x32, 0x32, 0x0a, 0x0a, 0x50,
+	0x64, 0x68, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x24, 0x0a, 0x08, 0x54, 0x72, 0x61,
+	0x6e, 0x73, 0x6d, 0x69, 0x74, 0x12, 0x08, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a,
+	0x08, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x00, 0x28, 0x01, 0x30, 0x01, 0x42,
+	0x0b, 0x5a, 0x09, 0x70, 0x64, 0x68, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -0,0 +1,205 @@
+package receiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"github.com/duyunis/pdh/files"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// extractTar unpacks the tar written to the returned writer while the data of the other arrives,
+// the error of the extraction is sent to the channel once the writer is closed.
+func (r *Receiver) extractTar(fileInfo *files.FileInfo) (*io.PipeWriter, chan error) {
+	pr, pw := io.Pipe()
+	extracted := make(chan error, 1)
+	go func() {
+		err := r.readTar(pr, fileInfo)
+		if err != nil {
+			// fails the writes of the file data
+			_ = pr.CloseWithError(err)
+		} else {
+			// padding after the end of the archive
+			_, _ = io.Copy(io.Discard, pr)
+		}
+		extracted <- err
+	}()
+	return pw, extracted
+}
+
+func (r *Receiver) readTar(reader io.Reader, fileInfo *files.FileInfo) error {
+	if fileInfo.Archive == files.ArchiveTarZst {
+		decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		reader = decoder
+	}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = r.extractFolder(fileInfo, header.Name)
+		case tar.TypeReg:
+			err = r.extractFile(fileInfo, header.Name, header.FileInfo().Mode(), header.ModTime, tr)
+		case tar.TypeSymlink:
+			err = r.extractLink(fileInfo, header.Name, header.Linkname, header.ModTime)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip unpacks the received zip at pathToFile next to it
+func (r *Receiver) extractZip(pathToFile string, fileInfo *files.FileInfo) error {
+	archive, err := zip.OpenReader(pathToFile)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err = r.extractFolder(fileInfo, f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		if mode&os.ModeSymlink == 0 && !mode.IsRegular() {
+			continue
+		}
+		data, err := f.Open()
+		if err != nil {
+			return err
+		}
+		if mode&os.ModeSymlink != 0 {
+			var target []byte
+			target, err = io.ReadAll(data)
+			if err == nil {
+				err = r.extractLink(fileInfo, f.Name, string(target), f.Modified)
+			}
+		} else {
+			err = r.extractFile(fileInfo, f.Name, mode, f.Modified, data)
+		}
+		_ = data.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePath is the path in the receive path of an entry of the archive
+func (r *Receiver) archivePath(fileInfo *files.FileInfo, name string) (string, error) {
+	rel := path.Join(fileInfo.FolderRemote, name)
+	return safePath(r.opt.OutPath, path.Dir(rel), path.Base(rel))
+}
+
+func (r *Receiver) extractFolder(fileInfo *files.FileInfo, name string) error {
+	pathToDir, err := safePath(r.opt.OutPath, path.Join(fileInfo.FolderRemote, name), "")
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(pathToDir, os.ModePerm)
+}
+
+func (r *Receiver) extractFile(fileInfo *files.FileInfo, name string, mode os.FileMode, modTime time.Time, data io.Reader) error {
+	pathToFile, err := r.archivePath(fileInfo, name)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(pathToFile), os.ModePerm); err != nil {
+		return err
+	}
+	target, err := r.resolveEntry(pathToFile, modTime)
+	if err != nil || target == "" {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(target)
+		return fmt.Errorf("extract [%s] failed: %w", target, err)
+	}
+	_ = os.Chmod(target, mode.Perm())
+	_ = os.Chtimes(target, modTime, modTime)
+	r.Lock()
+	r.receivedFiles = append(r.receivedFiles, target)
+	r.Unlock()
+	return nil
+}
+
+func (r *Receiver) extractLink(fileInfo *files.FileInfo, name string, link string, modTime time.Time) error {
+	pathToFile, err := r.archivePath(fileInfo, name)
+	if err != nil {
+		return err
+	}
+	if !r.opt.UnsafeSymlinks && !r.insideOutPath(pathToFile, link) {
+		r.logger.Warn(fmt.Sprintf("refuse symlink [%s] pointing outside the receive path: %s", pathToFile, link))
+		r.skipEntry(pathToFile)
+		return nil
+	}
+	if err = os.MkdirAll(filepath.Dir(pathToFile), os.ModePerm); err != nil {
+		return err
+	}
+	if existing, err := os.Readlink(pathToFile); err == nil && existing == link {
+		r.skipEntry(pathToFile)
+		return nil
+	}
+	target, err := r.resolveEntry(pathToFile, modTime)
+	if err != nil || target == "" {
+		return err
+	}
+	if err = os.Symlink(link, target); err != nil {
+		return err
+	}
+	r.Lock()
+	r.linkedFiles = append(r.linkedFiles, target)
+	r.Unlock()
+	return nil
+}
+
+// resolveEntry decides about an existing file at the path of an entry, it returns
+// the path to write the entry to, or "" when the entry is skipped.
+func (r *Receiver) resolveEntry(pathToFile string, modTime time.Time) (string, error) {
+	if _, err := os.Lstat(pathToFile); err != nil {
+		return pathToFile, nil
+	}
+	target, err := r.resolveConflict(pathToFile, &files.FileInfo{ModTime: modTime.UnixMilli()})
+	if err != nil {
+		return "", err
+	}
+	if target == "" {
+		r.skipEntry(pathToFile)
+	} else if target == pathToFile {
+		// replace a link itself, never write to where it points
+		_ = os.Remove(pathToFile)
+	}
+	return target, nil
+}
+
+func (r *Receiver) skipEntry(pathToFile string) {
+	r.Lock()
+	r.skippedFiles = append(r.skippedFiles, pathToFile)
+	r.Unlock()
+}
@@ -0,0 +1,61 @@
+package receiver
+
+import (
+	"fmt"
+	"github.com/duyunis/pdh/files"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveConflict decides about the file of the other that already exists at pathToFile,
+// it returns the path to write the file to, or "" to skip it.
+func (r *Receiver) resolveConflict(pathToFile string, fileInfo *files.FileInfo) (string, error) {
+	switch r.opt.OnConflict {
+	case "overwrite":
+		return pathToFile, nil
+	case "skip":
+		return "", nil
+	case "rename":
+		return renamedPath(pathToFile, fileInfo), nil
+	case "newer":
+		stat, err := os.Lstat(pathToFile)
+		if err == nil && fileInfo.ModTime > stat.ModTime().UnixMilli() {
+			return pathToFile, nil
+		}
+		return "", nil
+	case "fail":
+		return "", fmt.Errorf("file [%s] already exists", pathToFile)
+	}
+	if r.opt.Yes || r.prompter.Overwrite(pathToFile) {
+		return pathToFile, nil
+	}
+	return "", nil
+}
+
+// renamedPath returns the first "name (n).ext" next to pathToFile that doesn't exist yet,
+// or that is a partial file of the same source to continue.
+func renamedPath(pathToFile string, fileInfo *files.FileInfo) string {
+	dir, name := filepath.Split(pathToFile)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if stem == "" {
+		// a dotfile like .bashrc has no extension
+		stem, ext = name, ""
+	}
+	for n := 1; ; n++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) || resumePosition(candidate, fileInfo) > 0 {
+			return candidate
+		}
+	}
+}
+
+// checkConflictPolicy returns an error for an unknown OnConflict option
+func checkConflictPolicy(policy string) error {
+	switch policy {
+	case "", "ask", "overwrite", "skip", "rename", "newer", "fail":
+		return nil
+	}
+	return fmt.Errorf("unsupported conflict policy: %s", policy)
+}
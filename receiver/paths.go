@@ -0,0 +1,129 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errUnsafePath is returned for a path from the other that would be written outside the out path
+var errUnsafePath = errors.New("unsafe path")
+
+// safePath joins the out path with the folder and name of a file from the other.
+// Absolute paths, .. components and folders that are symlinks leading out of the
+// out path are refused, name may be empty for a folder.
+func safePath(outPath string, folder string, name string) (string, error) {
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("%w: invalid file name %q", errUnsafePath, name)
+	}
+	remote := folder + "/" + name
+	if strings.ContainsRune(remote, 0) {
+		return "", fmt.Errorf("%w: %q contains NUL", errUnsafePath, remote)
+	}
+	if strings.HasPrefix(folder, "/") || strings.HasPrefix(folder, `\`) || filepath.IsAbs(folder) || filepath.VolumeName(folder) != "" {
+		return "", fmt.Errorf("%w: absolute path %q", errUnsafePath, remote)
+	}
+	parts := strings.FieldsFunc(folder, func(c rune) bool {
+		return c == '/' || c == '\\'
+	})
+	for _, part := range parts {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q leaves the receive path", errUnsafePath, remote)
+		}
+	}
+
+	base, err := filepath.Abs(outPath)
+	if err != nil {
+		return "", err
+	}
+	realBase := base
+	if resolved, err := filepath.EvalSymlinks(base); err == nil {
+		realBase = resolved
+	}
+	current := base
+	for _, part := range parts {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			// doesn't exist yet, so nothing below it does
+			break
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(current)
+		if err != nil || !within(realBase, resolved) {
+			return "", fmt.Errorf("%w: %q is a symlink out of the receive path", errUnsafePath, current)
+		}
+	}
+	return filepath.Join(append([]string{outPath}, append(parts, name)...)...), nil
+}
+
+// insideOutPath reports whether the target of the symlink at pathToFile is in the receive path.
+// The parts of the target that exist already are resolved, so a chain of links can't lead out,
+// a .. after a part that doesn't exist yet is refused since a later link may take its place.
+func (r *Receiver) insideOutPath(pathToFile string, target string) bool {
+	outPath, err := realPath(r.opt.OutPath)
+	if err != nil {
+		return false
+	}
+	current := filepath.VolumeName(target) + string(filepath.Separator)
+	if !filepath.IsAbs(target) {
+		if current, err = realPath(filepath.Dir(pathToFile)); err != nil {
+			return false
+		}
+	}
+	parts := strings.FieldsFunc(target[len(filepath.VolumeName(target)):], func(c rune) bool {
+		return c == '/' || c == filepath.Separator
+	})
+	missing := false
+	for _, part := range parts {
+		switch {
+		case part == ".":
+		case part == ".." && missing:
+			return false
+		case part == "..":
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, part)
+			if missing {
+				continue
+			}
+			info, err := os.Lstat(current)
+			if err != nil {
+				missing = true
+				continue
+			}
+			if info.Mode()&os.ModeSymlink == 0 {
+				continue
+			}
+			resolved, err := filepath.EvalSymlinks(current)
+			if err != nil {
+				missing = true
+				continue
+			}
+			current = resolved
+		}
+	}
+	return within(outPath, current)
+}
+
+// realPath is the absolute path with its symlinks resolved, as is if it doesn't exist
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+	return abs, nil
+}
+
+// within reports whether the absolute path target is base or below it
+func within(base string, target string) bool {
+	rel, err := filepath.Rel(base, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,120 @@
+package receiver
+
+import (
+	"errors"
+	"github.com/duyunis/pdh/options"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafePathRefusesHostileManifests(t *testing.T) {
+	outPath := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(outPath, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(outPath, "inside", "real"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../etc", filepath.Join(outPath, "inside", "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	hostile := []struct {
+		folder string
+		name   string
+	}{
+		{"../../.ssh/", "authorized_keys"},
+		{"./", "../authorized_keys"},
+		{"./", ".."},
+		{"./", "."},
+		{"a/../../", "passwd"},
+		{`a\..\..\`, "passwd"},
+		{"/etc/", "passwd"},
+		{`\etc\`, "passwd"},
+		{"./", `..\passwd`},
+		{"./", "a/b"},
+		{"./", "pass\x00wd"},
+		{"escape/", "authorized_keys"},
+		{"escape/deeper/", "authorized_keys"},
+		{"inside/up/", "passwd"},
+	}
+	for _, h := range hostile {
+		p, err := safePath(outPath, h.folder, h.name)
+		if !errors.Is(err, errUnsafePath) {
+			t.Errorf("safePath(%q, %q) = %q, %v, want unsafe path", h.folder, h.name, p, err)
+		}
+	}
+}
+
+func TestSafePathAcceptsFilesInOutPath(t *testing.T) {
+	outPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(outPath, "real"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real", filepath.Join(outPath, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		folder string
+		name   string
+		want   string
+	}{
+		{"./", "a.txt", filepath.Join(outPath, "a.txt")},
+		{"src/sub/", "c.txt", filepath.Join(outPath, "src", "sub", "c.txt")},
+		{"src/..dots/", "..c", filepath.Join(outPath, "src", "..dots", "..c")},
+		{"link/", "b.txt", filepath.Join(outPath, "link", "b.txt")},
+		{"src/empty/", "", filepath.Join(outPath, "src", "empty")},
+	}
+	for _, c := range cases {
+		p, err := safePath(outPath, c.folder, c.name)
+		if err != nil || p != c.want {
+			t.Errorf("safePath(%q, %q) = %q, %v, want %q", c.folder, c.name, p, err, c.want)
+		}
+	}
+}
+
+func TestInsideOutPathFollowsLinks(t *testing.T) {
+	outPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(outPath, "sub"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	// accepted on their own, y -> . and z -> y
+	for link, target := range map[string]string{"y": ".", "z": "y", "dangling": "nowhere"} {
+		if err := os.Symlink(target, filepath.Join(outPath, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Receiver{opt: &options.ReceiverOptions{OutPath: outPath}}
+
+	cases := []struct {
+		link   string
+		target string
+		want   bool
+	}{
+		{"x", "file", true},
+		{"x", ".", true},
+		{"x", "sub/../file", true},
+		{"x", "y/file", true},
+		{"x", "z/sub", true},
+		{"sub/x", "../file", true},
+		{"sub/x", "../y/sub", true},
+		{"x", filepath.Join(outPath, "sub"), true},
+		{"x", "..", false},
+		{"x", "/etc/passwd", false},
+		{"sub/x", "../..", false},
+		{"x", "y/..", false},
+		{"x", "z/..", false},
+		{"sub/x", "../y/../file", false},
+		{"x", "missing/..", false},
+		{"x", "dangling/../..", false},
+	}
+	for _, c := range cases {
+		got := r.insideOutPath(filepath.Join(outPath, c.link), c.target)
+		if got != c.want {
+			t.Errorf("insideOutPath(%q -> %q) = %v, want %v", c.link, c.target, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package receiver
+
+// Offer is what the other wants to send
+type Offer struct {
+	Files   int64
+	Folders int64
+	// Size of all files, unknown for a stream
+	Size   int64
+	Stream bool
+}
+
+// Prompter makes the decisions the receiver leaves to the user
+type Prompter interface {
+	// AcceptTransfer is asked once before anything is received
+	AcceptTransfer(offer *Offer) bool
+	// Overwrite is asked for each file that already exists in the receive path
+	Overwrite(pathToFile string) bool
+}
+
+// acceptAll accepts every transfer and never overwrites, used without a Prompter
+type acceptAll struct{}
+
+func (acceptAll) AcceptTransfer(*Offer) bool { return true }
+func (acceptAll) Overwrite(string) bool      { return false }
@@ -1,116 +1,200 @@
 package receiver
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/duyunis/discovery"
 	"github.com/duyunis/pdh/common"
 	"github.com/duyunis/pdh/compress"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/event"
+	"github.com/duyunis/pdh/files"
 	"github.com/duyunis/pdh/message"
 	"github.com/duyunis/pdh/options"
 	"github.com/duyunis/pdh/proto"
 	"github.com/duyunis/pdh/tools"
 	"github.com/duyunis/pdh/transmit"
 	"github.com/duyunis/pdh/transmit/client"
-	"github.com/duyunis/progress_bar"
+	"github.com/schollz/pake/v3"
+	"io"
 	"net"
 	"os"
-	"os/signal"
-	"path"
-	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
+// maxFiles bounds the number of files a file stat may announce
+const maxFiles = 1 << 24
+
 type Receiver struct {
-	opt                 *options.ReceiverOptions
-	gc                  *client.GrpcClient
-	wg                  sync.WaitGroup
-	currentFile         *os.File
-	currentFinish       bool
-	filesSize           int64
+	common.RWMutex
+	opt          *options.ReceiverOptions
+	logger       tools.Logger
+	events       *event.Emitter
+	prompter     Prompter
+	gc           *client.GrpcClient
+	dataClients  []*client.GrpcClient
+	localAddress string
+	// relayOpt connects the relay clients
+	relayOpt *options.GrpcClientOptions
+	// localFingerprint is the fingerprint of the certificate the local network server presented
+	localFingerprint []byte
+	pake             *pake.Pake
+	key              []byte
+	wg               sync.WaitGroup
+	// announcedFiles is the number of files of the file stat, each file info of the other
+	// counts in startedFiles and is one of wg
+	announcedFiles int64
+	startedFiles   int64
+	// sink receives the file data instead of a file in the out path
+	sink io.Writer
+	// writing is set while the data of a file is written in the background
+	writing       bool
+	filesSize     int64
+	receivedBytes int64
+	text          string
+	receivedFiles []string
+	verifiedFiles []string
+	linkedFiles   []string
+	skippedFiles  []string
+	failedFiles   []string
+	// fetching is set once the relay is asked for a stored transfer, stored is what it gave
+	fetching            bool
+	fetched             chan struct{}
+	stored              *storedTransfer
 	fileHandleMsg       chan *proto.Message
-	done                chan bool
 	latestFileWriteDone chan bool
+	finishOnce          sync.Once
+	err                 error
+	done                chan struct{}
 }
 
-func (r *Receiver) Receive() {
-	var err error
+// Receive receives into the out path of the options, or into sink when it isn't nil,
+// it returns once everything is received, the transfer failed or ctx is done.
+func (r *Receiver) Receive(ctx context.Context, sink io.Writer) (*common.Result, error) {
+	r.sink = sink
+	defer r.cleanup()
 
-	err = r.receiveFromLocalNetwork()
+	err := r.receiveFromLocalNetwork()
 	if err != nil {
 		err = r.receiveFromRelay()
 	}
 	if err != nil {
-		tools.Println(tools.Red, fmt.Sprintf("error occurred, %s", err))
-		r.Done()
+		return nil, fmt.Errorf("error occurred, %w", err)
+	}
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		r.RLock()
+		gc := r.gc
+		r.RUnlock()
+		if gc != nil {
+			_ = gc.Send(message.NewMessage(proto.MessageType_Interrupt, nil))
+		}
+		r.finish(ctx.Err())
 	}
-	go r.signal()
-	<-r.done
+	return r.result(), r.err
 }
 
 func (r *Receiver) receiveFromLocalNetwork() error {
 	opt := &discovery.Options{
 		Limit:     1,
 		TimeLimit: time.Second * 5,
-		Payload:   []byte(r.opt.ShareCode),
+		Payload:   []byte(crypt.ChannelName(r.opt.ShareCode)),
 	}
 	discover := discovery.NewDiscover(opt)
 	broadcast, err := discover.DiscoverBroadcast()
 	if err != nil {
 		return err
 	}
-	if len(broadcast) > 0 {
-		hostPort := net.JoinHostPort(broadcast[0].Address, r.opt.LocalPort)
-		gc := client.NewPdhGrpcClient(hostPort)
-		err = gc.Start()
-
-		if err != nil {
-			tools.Println(tools.Red, err)
-			r.Done()
-		}
-		err = gc.Send(message.NewMessage(proto.MessageType_LocalNetworkMode, nil))
-		err = gc.Send(message.NewMessage(proto.MessageType_GetFileStat, nil))
-		if err != nil {
-			tools.Println(tools.Red, "stream is error.")
-			r.Done()
-		}
-		r.gc = gc
-		gc.AddHandler(r)
-		return nil
+	if len(broadcast) == 0 {
+		return errors.New("not discovery on local network")
+	}
+	hostPort := net.JoinHostPort(broadcast[0].Address, r.opt.LocalPort)
+	gc := client.NewPdhGrpcClientWithOptions(hostPort, &options.GrpcClientOptions{
+		TLSConfig: client.PinnedTLSConfig(nil, func(fingerprint []byte) {
+			r.Lock()
+			r.localFingerprint = fingerprint
+			r.Unlock()
+		}),
+	})
+	err = gc.Start()
+	if err != nil {
+		return err
+	}
+	r.localAddress = hostPort
+	r.Lock()
+	r.gc = gc
+	r.Unlock()
+	gc.AddHandler(r)
+	err = gc.Send(message.NewMessage(proto.MessageType_LocalNetworkMode, nil))
+	if err == nil {
+		err = r.startKeyExchange(gc)
 	}
-	return errors.New("not discovery on local network")
+	if err != nil {
+		r.finish(fmt.Errorf("stream is error: %w", err))
+	}
+	return nil
 }
 
 func (r *Receiver) receiveFromRelay() error {
-	fmt.Print("\rConnecting...")
-	gc := client.NewPdhGrpcClient(r.opt.Relay)
+	r.logger.Info("Connecting...")
+	gc := client.NewPdhGrpcClientWithOptions(r.opt.Relay, r.relayOpt)
 	gc.AddHandler(r)
 	err := gc.Start()
 	if err != nil {
 		return err
 	}
+	r.Lock()
 	r.gc = gc
-	return r.gc.Send(message.NewMessage(proto.MessageType_JoinChannel, []byte(r.opt.ShareCode)))
+	r.Unlock()
+	return gc.Send(message.NewMessage(proto.MessageType_JoinChannel, []byte(crypt.ChannelName(r.opt.ShareCode))))
 }
 
-func (r *Receiver) Done() {
+// finish ends the transfer, the first call decides the error Receive returns
+func (r *Receiver) finish(err error) {
+	r.finishOnce.Do(func() {
+		r.err = err
+		if err != nil {
+			r.events.Emit(event.Event{Type: event.TransferFailed, Err: err})
+		} else {
+			r.events.Emit(event.Event{Type: event.Completed})
+		}
+		close(r.done)
+	})
+}
+
+// cleanup closes the connections once the transfer ended
+func (r *Receiver) cleanup() {
 	// sleep, send an end message to the other.
 	time.Sleep(time.Second)
-	r.done <- true
-	r.latestFileWriteDone <- true
+	r.Lock()
+	defer r.Unlock()
+	if r.gc != nil {
+		r.gc.Stop()
+		r.gc = nil
+	}
+	for _, gc := range r.dataClients {
+		gc.Stop()
+	}
+	r.dataClients = nil
 }
 
-func (r *Receiver) signal() {
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
-	for {
-		select {
-		case <-interrupt:
-			_ = r.gc.Send(message.NewMessage(proto.MessageType_Interrupt, nil))
-			r.Done()
-		}
+// result collects what was received so far
+func (r *Receiver) result() *common.Result {
+	r.RLock()
+	defer r.RUnlock()
+	return &common.Result{
+		ShareCode: r.opt.ShareCode,
+		Files:     r.receivedFiles,
+		Verified:  r.verifiedFiles,
+		Linked:    r.linkedFiles,
+		Skipped:   r.skippedFiles,
+		Failed:    r.failedFiles,
+		Bytes:     r.receivedBytes,
+		Text:      r.text,
 	}
 }
 
@@ -118,185 +202,785 @@ func (r *Receiver) HandleMessage(stream transmit.GrpcStream, msg *proto.Message)
 	var err error
 	switch msg.MessageType {
 	case proto.MessageType_Interrupt:
-		fmt.Println("\rreceive interrupt...")
-		r.Done()
+		r.finish(errors.New("interrupted by the other"))
 	case proto.MessageType_ChannelFull:
-		tools.Println(tools.Red, "\rchannel is full, someone else has already received it.")
-		r.Done()
+		r.finish(errors.New("channel is full, someone else has already received it"))
 	case proto.MessageType_JoinChannelSuccess:
-		fmt.Print("\rjoin channel success.")
-		err = stream.Send(message.NewMessage(proto.MessageType_GetFileStat, nil))
+		r.logger.Info("join channel success.")
+		err = r.startKeyExchange(stream)
 		if err != nil {
-			tools.Println(tools.Red, "stream is error.")
-			r.Done()
+			r.finish(fmt.Errorf("key exchange failed: %w", err))
+		}
+	case proto.MessageType_KeyExchange:
+		err = r.finishKeyExchange(msg)
+		if err != nil {
+			r.finish(fmt.Errorf("key exchange failed: %w", err))
+			return
+		}
+		// tell the other which compression codecs we understand
+		getFileStat := &message.GetFileStatPayload{Codecs: compress.Names()}
+		payload, _ := getFileStat.Bytes(message.JSONProtocol)
+		gsm, err := message.NewEncryptedMessage(proto.MessageType_GetFileStat, payload, r.key)
+		if err == nil {
+			err = stream.Send(gsm)
 		}
+		if err != nil {
+			r.finish(fmt.Errorf("stream is error: %w", err))
+		}
+	case proto.MessageType_Failed:
+		r.finish(fmt.Errorf("the other failed: %s", string(msg.Payload)))
 	case proto.MessageType_ChannelNotFound:
-		tools.Println(tools.Red, "\rchannel not found, please check your share code.")
-		r.Done()
+		if r.localAddress == "" && !r.fetching {
+			// the sender may have stored the transfer on the relay
+			r.fetchStored(stream)
+			return
+		}
+		r.finish(errors.New("channel not found, please check your share code"))
+	case proto.MessageType_StoreFailed:
+		r.finish(fmt.Errorf("the relay can't give the stored transfer: %s", string(msg.Payload)))
+	case proto.MessageType_FetchSuccess:
+		r.receiveStored(stream, msg)
+	case proto.MessageType_StoreData:
+		r.writeStored(msg)
+	case proto.MessageType_StoreFinish:
+		r.finishStored()
 	case proto.MessageType_JoinChannelFailed:
-		tools.Println(tools.Red, "\rjoin channel failed.")
-		r.Done()
+		r.finish(errors.New("join channel failed"))
+	case proto.MessageType_Unauthorized:
+		r.finish(errors.New("the relay refused the token, set it with --relay-token"))
+	case proto.MessageType_TooManyJoins:
+		r.finish(errors.New("the relay refused to join, this computer tried too many share codes, try again in a minute"))
+	case proto.MessageType_ChannelQuotaExceeded:
+		r.finish(errors.New("the transfer is larger than the relay allows"))
+	case proto.MessageType_TooManyChannels:
+		r.finish(errors.New("the relay refused the download, this computer has too many transfers open"))
+	case proto.MessageType_RelayFull:
+		r.finish(errors.New("the relay is full, try again later"))
 	case proto.MessageType_FileFinish:
-		r.Done()
+		r.finish(r.failed())
 	case proto.MessageType_FileStat:
-		pm, err := message.ParseMessagePayload(msg)
+		pm, err := message.ParseEncryptedMessagePayload(msg, r.key)
 		if err != nil {
-			tools.Println(tools.Red, "\rget file stat failed.")
-			r.Done()
+			r.finish(fmt.Errorf("get file stat failed: %w, please check your share code", err))
+			return
+		}
+		stat, ok := pm.(*message.FileStatPayload)
+		if !ok || stat == nil {
+			r.finish(errors.New("get file stat failed: invalid file stat"))
+			return
+		}
+		if stat.Text != "" {
+			r.receiveText(stream, stat.Text)
+			return
+		}
+		if stat.FilesNumber < 0 || stat.FilesNumber > maxFiles {
+			r.abort(stream, fmt.Errorf("implausible number of files: %d", stat.FilesNumber))
+			return
+		}
+		if r.sink != nil && stat.FilesNumber > 1 {
+			_ = stream.Send(message.NewMessage(proto.MessageType_RefuseReceive, nil))
+			r.finish(fmt.Errorf("can't write %d files to a single output", stat.FilesNumber))
+			return
+		}
+		if r.opt.MaxSize > 0 && stat.FilesSize > r.opt.MaxSize {
+			_ = stream.Send(message.NewMessage(proto.MessageType_RefuseReceive, nil))
+			r.finish(fmt.Errorf("refused %s, the max size is %s", tools.ByteCountDecimal(stat.FilesSize), tools.ByteCountDecimal(r.opt.MaxSize)))
 			return
 		}
-		stat := pm.(*message.FileStatPayload)
-		fmt.Printf("\rAccept %d files and %d folders (%s)? (Y/n)", stat.FilesNumber, stat.FolderNumber, tools.ByteCountDecimal(stat.FilesSize))
-		fmt.Println()
-		choice := strings.ToLower(tools.GetInput(""))
-		if choice != "" && choice != "y" && choice != "yes" {
+		if r.localAddress != "" {
+			r.RLock()
+			pinned := bytes.Equal(r.localFingerprint, stat.CertFingerprint)
+			r.RUnlock()
+			if !pinned {
+				r.abort(stream, errors.New("certificate of the local network server doesn't match"))
+				return
+			}
+		}
+		for _, folder := range stat.EmptyFolders {
+			if _, err = safePath(r.opt.OutPath, folder, ""); err != nil {
+				r.abort(stream, err)
+				return
+			}
+		}
+		offer := &Offer{
+			Files:   stat.FilesNumber,
+			Folders: stat.FolderNumber,
+			Size:    stat.FilesSize,
+			Stream:  stat.Stream && stat.FilesNumber == 1,
+		}
+		if !r.opt.Yes && !r.prompter.AcceptTransfer(offer) {
 			_ = stream.Send(message.NewMessage(proto.MessageType_RefuseReceive, nil))
-			r.Done()
+			r.finish(nil)
 			return
 		}
 		r.filesSize = stat.FilesSize
-		err = stream.Send(message.NewMessage(proto.MessageType_AgreeReceive, nil))
+		r.events.Emit(event.Event{Type: event.TransferStarted, Files: stat.FilesNumber, Size: stat.FilesSize})
+		r.createEmptyFolders(stat.EmptyFolders)
+		agree := &message.AgreeReceivePayload{Streams: 1}
+		if stat.Streams > 1 && r.sink == nil {
+			agree.Streams = int64(r.openDataStreams(int(stat.Streams)))
+		}
+		payload, _ := agree.Bytes(message.JSONProtocol)
+		am, err := message.NewEncryptedMessage(proto.MessageType_AgreeReceive, payload, r.key)
+		if err == nil {
+			err = stream.Send(am)
+		}
 		if err != nil {
-			tools.Println(tools.Red, "\rstream is error.")
-			r.Done()
+			r.finish(fmt.Errorf("stream is error: %w", err))
+			return
 		}
-		fmt.Println()
-		fmt.Println("Receiving...")
-		fmt.Println()
+		r.logger.Info("")
+		r.logger.Info("Receiving...")
+		r.logger.Info("")
+		r.Lock()
+		r.announcedFiles = stat.FilesNumber
+		r.Unlock()
 		r.wg.Add(int(stat.FilesNumber))
 		go func() {
 			r.wg.Wait()
-			fmt.Println("Receive Completed!")
-			r.Done()
+			r.finish(r.failed())
 		}()
 	case proto.MessageType_FileData:
-		r.fileHandleMsg <- msg
+		select {
+		case r.fileHandleMsg <- msg:
+		case <-r.done:
+		}
 	case proto.MessageType_FileInfo:
-		if r.currentFile != nil {
-			<-r.latestFileWriteDone
+		if r.writing {
+			select {
+			case <-r.latestFileWriteDone:
+			case <-r.done:
+				return
+			}
 		}
-		pm, err := message.ParseMessagePayload(msg)
+		pm, err := message.ParseEncryptedMessagePayload(msg, r.key)
 		if err != nil {
-			tools.Println(tools.Red, fmt.Sprintf("get file info failed: %s", err))
-			r.Done()
+			r.finish(fmt.Errorf("get file info failed: %w", err))
 			return
 		}
-		infoPayload := pm.(*message.FileInfoPayload)
-		if infoPayload == nil {
-			tools.Println(tools.Red, fmt.Sprintf("get file info failed: %s", err))
-			r.Done()
+		infoPayload, ok := pm.(*message.FileInfoPayload)
+		if !ok || infoPayload == nil {
+			r.finish(errors.New("get file info failed: empty file info"))
 			return
 		}
 		fileInfo := infoPayload.FileInfo
-		if fileInfo != nil {
-			pathToDir := path.Join(r.opt.OutPath, fileInfo.FolderRemote)
-			pathToFile := path.Join(r.opt.OutPath, fileInfo.FolderRemote, fileInfo.Name)
-			boo := tools.IsFile(pathToDir)
-			if !boo {
-				if err = os.MkdirAll(pathToDir, os.ModePerm); err != nil {
-					tools.Println(tools.Red, fmt.Sprintf("create folder failed, %s", err))
-					return
-				}
-			}
-			boo = tools.IsFile(pathToFile)
-			if boo {
-				// file existed
-				fmt.Printf("\rFile %s is existed, do you want to overwrite it? (Y/n)", fileInfo.Name)
-				fmt.Println()
-				choice := strings.ToLower(tools.GetInput(""))
-				if choice != "" && choice != "y" && choice != "yes" {
-					_ = stream.Send(message.NewMessage(proto.MessageType_SkipFile, nil))
-					return
-				}
-				r.currentFile, err = os.OpenFile(pathToFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, os.ModePerm)
-			} else {
-				r.currentFile, err = os.Create(pathToFile)
+		if fileInfo != nil && !fileInfo.IsEncrypted {
+			r.abort(stream, fmt.Errorf("refuse to receive unencrypted file [%s]", fileInfo.Name))
+			return
+		}
+		if fileInfo == nil {
+			return
+		}
+		if !r.countFile() {
+			r.abort(stream, errors.New("the other sent more files than announced"))
+			return
+		}
+		r.receiveFile(stream, fileInfo)
+	}
+
+}
+
+// countFile counts a file info of the other, false once there are more than the file stat announced
+func (r *Receiver) countFile() bool {
+	r.Lock()
+	defer r.Unlock()
+	r.startedFiles++
+	return r.startedFiles <= r.announcedFiles
+}
+
+// abort cancels the transfer of the other
+func (r *Receiver) abort(stream transmit.GrpcStream, err error) {
+	_ = stream.Send(message.NewMessage(proto.MessageType_Cancel, nil))
+	r.finish(fmt.Errorf("transfer aborted: %w", err))
+}
+
+// failed returns an error when any file failed
+func (r *Receiver) failed() error {
+	r.RLock()
+	defer r.RUnlock()
+	if len(r.failedFiles) > 0 {
+		return fmt.Errorf("%d files failed", len(r.failedFiles))
+	}
+	return nil
+}
+
+// createEmptyFolders creates the empty folders of the other in the out path
+func (r *Receiver) createEmptyFolders(folders []string) {
+	if r.sink != nil {
+		return
+	}
+	for _, folder := range folders {
+		pathToDir, err := safePath(r.opt.OutPath, folder, "")
+		if err == nil {
+			err = os.MkdirAll(pathToDir, os.ModePerm)
+		}
+		if err != nil {
+			r.logger.Error(fmt.Sprintf("create folder failed, %s", err))
+		}
+	}
+}
+
+// receiveText writes the text of the other to the sink or the out path,
+// without either it's only in the result.
+func (r *Receiver) receiveText(stream transmit.GrpcStream, text string) {
+	err := r.writeText(text)
+	if err != nil {
+		_ = stream.Send(message.NewMessage(proto.MessageType_RefuseReceive, nil))
+		r.finish(fmt.Errorf("write text failed: %w", err))
+		return
+	}
+	agree := &message.AgreeReceivePayload{Streams: 1}
+	payload, _ := agree.Bytes(message.JSONProtocol)
+	am, err := message.NewEncryptedMessage(proto.MessageType_AgreeReceive, payload, r.key)
+	if err == nil {
+		err = stream.Send(am)
+	}
+	if err != nil {
+		r.finish(fmt.Errorf("stream is error: %w", err))
+		return
+	}
+	r.finish(nil)
+}
+
+// writeText writes the text to the sink or the out path and keeps it for the result
+func (r *Receiver) writeText(text string) error {
+	var err error
+	if r.sink != nil {
+		_, err = fmt.Fprintln(r.sink, text)
+	} else if r.opt.OutPath != "" {
+		err = os.WriteFile(r.opt.OutPath, []byte(text), 0644)
+		if err == nil {
+			r.logger.Info(fmt.Sprintf("Text (%s) written to %s", tools.ByteCountDecimal(int64(len(text))), r.opt.OutPath))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	r.text = text
+	r.Unlock()
+	return nil
+}
+
+// receiveFile prepares the file and writes the file data of the other in the background
+func (r *Receiver) receiveFile(stream transmit.GrpcStream, fileInfo *files.FileInfo) {
+	var (
+		pathToFile string
+		file       *os.File
+		position   int64
+		state      *resumeState
+		err        error
+		// out takes the file data instead of file, in order
+		out io.Writer = r.sink
+		// extracted gets the result of unpacking an archive while it arrives
+		extracted chan error
+		codec     compress.Codec
+	)
+	if fileInfo.IsCompressed && fileInfo.Codec != "" {
+		codec, err = compress.Get(fileInfo.Codec, 0)
+		if err == nil && codec == nil {
+			err = fmt.Errorf("unsupported compression: %s", fileInfo.Codec)
+		}
+		if err != nil {
+			r.abort(stream, err)
+			return
+		}
+	}
+	if r.sink != nil && fileInfo.Symlink != "" {
+		r.skipFile(stream, fileInfo.Name, fileInfo)
+		return
+	} else if r.sink != nil {
+		// nothing on disk to resume, skip or verify
+		pathToFile = fileInfo.Name
+	} else if r.opt.Extract && fileInfo.Stream && fileInfo.Archive != "" {
+		// the archive itself is never written
+		pathToFile, err = safePath(r.opt.OutPath, fileInfo.FolderRemote, fileInfo.Name)
+		if err != nil {
+			r.abort(stream, err)
+			return
+		}
+		out, extracted = r.extractTar(fileInfo)
+	} else {
+		var pathToDir string
+		pathToDir, err = safePath(r.opt.OutPath, fileInfo.FolderRemote, "")
+		if err == nil {
+			pathToFile, err = safePath(r.opt.OutPath, fileInfo.FolderRemote, fileInfo.Name)
+		}
+		if err == nil && fileInfo.Name == "" {
+			err = fmt.Errorf("%w: empty file name", errUnsafePath)
+		}
+		if err != nil {
+			r.abort(stream, err)
+			return
+		}
+		if !tools.IsFile(pathToDir) {
+			if err = os.MkdirAll(pathToDir, os.ModePerm); err != nil {
+				r.abort(stream, err)
+				return
 			}
+		}
+		if fileInfo.Symlink != "" {
+			r.linkFile(stream, pathToFile, fileInfo)
+			return
+		}
+		position = resumePosition(pathToFile, fileInfo)
+		stat, statErr := os.Lstat(pathToFile)
+		boo := statErr == nil
+		if position > 0 {
+			// continue the interrupted transfer of the same file
+			file, err = os.OpenFile(pathToFile, os.O_WRONLY, os.ModePerm)
+		} else if boo && r.opt.SkipExisting && isSameFile(pathToFile, fileInfo) {
+			r.skipFile(stream, pathToFile, fileInfo)
+			return
+		} else if boo {
+			// file existed
+			var target string
+			target, err = r.resolveConflict(pathToFile, fileInfo)
 			if err != nil {
-				tools.Println(tools.Red, fmt.Sprintf("create or open file [%s] failed, %s", pathToFile, err))
-				r.Done()
+				r.abort(stream, err)
 				return
 			}
-			err = r.currentFile.Truncate(fileInfo.Size)
-			if err != nil {
-				tools.Println(tools.Red, fmt.Sprintf("could not truncate [%s]: %s", pathToFile, err))
-				r.Done()
+			if target == "" {
+				r.skipFile(stream, pathToFile, fileInfo)
 				return
 			}
-			// ready
-			err = stream.Send(message.NewMessage(proto.MessageType_ReadyForReceive, nil))
+			if target != pathToFile {
+				// renamed, it may be a partial file of an earlier try
+				pathToFile = target
+				position = resumePosition(pathToFile, fileInfo)
+			} else if stat.Mode()&os.ModeSymlink != 0 {
+				// replace the link itself, never write to where it points
+				_ = os.Remove(pathToFile)
+			}
+			flag := os.O_WRONLY | os.O_CREATE
+			if position == 0 {
+				flag |= os.O_TRUNC
+			}
+			file, err = os.OpenFile(pathToFile, flag, os.ModePerm)
+		} else {
+			file, err = os.Create(pathToFile)
+		}
+		if err != nil {
+			r.finish(fmt.Errorf("create or open file [%s] failed, %w", pathToFile, err))
+			return
+		}
+		err = file.Truncate(fileInfo.Size)
+		if err != nil {
+			_ = file.Close()
+			r.finish(fmt.Errorf("could not truncate [%s]: %w", pathToFile, err))
+			return
+		}
+		if len(fileInfo.Hash) > 0 {
+			state = &resumeState{
+				Hash:     fileInfo.Hash,
+				Size:     fileInfo.Size,
+				Position: position,
+			}
+			err = saveResumeState(pathToFile, state)
 			if err != nil {
-				tools.Println(tools.Red, fmt.Sprintf("stream is error: %s", err))
-				r.Done()
+				_ = file.Close()
+				r.finish(fmt.Errorf("could not save transfer state of [%s]: %w", pathToFile, err))
 				return
 			}
-			barOpt := &progress_bar.Options{
-				Describe:     fileInfo.Name,
-				Graph:        ">",
-				IsBytes:      true,
-				ShowPercent:  true,
-				ShowDuration: true,
+		}
+	}
+	if position > 0 {
+		resume := &message.ResumePayload{Position: position}
+		payload, _ := resume.Bytes(message.JSONProtocol)
+		rm, err := message.NewEncryptedMessage(proto.MessageType_ResumeReceive, payload, r.key)
+		if err != nil {
+			_ = file.Close()
+			r.finish(fmt.Errorf("encrypt resume position error: %w", err))
+			return
+		}
+		r.logger.Warn(fmt.Sprintf("resume [%s] from %s", fileInfo.Name, tools.ByteCountDecimal(position)))
+		err = stream.Send(rm)
+	} else {
+		// ready
+		err = stream.Send(message.NewMessage(proto.MessageType_ReadyForReceive, nil))
+	}
+	if err != nil {
+		if file != nil {
+			_ = file.Close()
+		}
+		r.finish(fmt.Errorf("stream is error: %w", err))
+		return
+	}
+	// the size of a stream is known at its EOF
+	size := fileInfo.Size
+	if fileInfo.Stream {
+		size = -1
+	}
+	r.events.Emit(event.Event{Type: event.FileStarted, File: fileInfo.Name, Size: size, Bytes: position})
+	// chunks may arrive out of order over several streams, only the
+	// contiguous part from the start is confirmed for resuming.
+	confirmed := position
+	received := position
+	pending := make(map[int64]int64)
+	r.writing = true
+	go func() {
+		defer func() {
+			if file != nil {
+				_ = file.Close()
 			}
-			bar := progress_bar.NewBarWithOptions(fileInfo.Size, barOpt)
-
-			writePosition := int64(0)
-			go func() {
-			LOOP:
-				for {
-					select {
-					case m := <-r.fileHandleMsg:
-						switch m.MessageType {
-						case proto.MessageType_FileData:
-							pmp, _ := message.ParseMessagePayload(m)
-							fileDataMsg := pmp.(*message.FileDataPayload)
-							if fileDataMsg.Data != nil {
-								receiveData := compress.Decompress(fileDataMsg.Data)
-								_, err = r.currentFile.Write(receiveData)
-								if err != nil {
-									tools.Println(tools.Red, fmt.Sprintf("write file [%s] failed: %s", pathToFile, err))
-									_ = os.Remove(r.currentFile.Name())
-									r.Done()
-									break LOOP
-								}
-								writePosition = fileDataMsg.Position
-								bar.Add(writePosition)
-								if fileDataMsg.EOF {
-									bar.Finish()
-									err = r.currentFile.Close()
-									r.latestFileWriteDone <- true
-									break LOOP
+			if pw, ok := out.(*io.PipeWriter); ok {
+				// stops the extraction of an incomplete archive
+				_ = pw.CloseWithError(errors.New("archive incomplete"))
+			}
+			r.wg.Done()
+		}()
+	LOOP:
+		for {
+			select {
+			case <-r.done:
+				break LOOP
+			case m := <-r.fileHandleMsg:
+				switch m.MessageType {
+				case proto.MessageType_FileData:
+					pmp, err := message.ParseEncryptedMessagePayload(m, r.key)
+					if err != nil {
+						r.discardFile(pathToFile)
+						err = fmt.Errorf("decrypt file [%s] data failed: %w", pathToFile, err)
+						r.events.Emit(event.Event{Type: event.FileFailed, File: fileInfo.Name, Err: err})
+						r.finish(err)
+						break LOOP
+					}
+					fileDataMsg, ok := pmp.(*message.FileDataPayload)
+					if !ok || fileDataMsg == nil {
+						r.discardFile(pathToFile)
+						err = fmt.Errorf("decrypt file [%s] data failed: invalid file data", pathToFile)
+						r.events.Emit(event.Event{Type: event.FileFailed, File: fileInfo.Name, Err: err})
+						r.finish(err)
+						break LOOP
+					}
+					if fileDataMsg.Data != nil {
+						receiveData, err := decompress(fileInfo, codec, fileDataMsg)
+						if err != nil {
+							r.discardFile(pathToFile)
+							err = fmt.Errorf("decompress file [%s] data failed: %w", pathToFile, err)
+							r.events.Emit(event.Event{Type: event.FileFailed, File: fileInfo.Name, Err: err})
+							r.finish(err)
+							break LOOP
+						}
+						// position is where the chunk ends
+						start := fileDataMsg.Position - int64(len(receiveData))
+						r.RLock()
+						exceeded := r.opt.MaxSize > 0 && r.receivedBytes+int64(len(receiveData)) > r.opt.MaxSize
+						r.RUnlock()
+						if exceeded {
+							// a stream has no size to refuse it before
+							r.discardFile(pathToFile)
+							err = fmt.Errorf("received more than the max size of %s", tools.ByteCountDecimal(r.opt.MaxSize))
+							r.events.Emit(event.Event{Type: event.FileFailed, File: fileInfo.Name, Err: err})
+							r.abort(stream, err)
+							break LOOP
+						}
+						if out != nil {
+							// the sink can't seek, the chunks come in order over a single stream
+							if start != confirmed {
+								err = errors.New("file data out of order")
+							} else {
+								_, err = out.Write(receiveData)
+							}
+						} else {
+							_, err = file.WriteAt(receiveData, start)
+						}
+						if err != nil {
+							r.discardFile(pathToFile)
+							if extracted != nil {
+								err = fmt.Errorf("extract [%s] failed: %w", pathToFile, err)
+							} else {
+								err = fmt.Errorf("write file [%s] failed: %w", pathToFile, err)
+							}
+							r.events.Emit(event.Event{Type: event.FileFailed, File: fileInfo.Name, Err: err})
+							r.finish(err)
+							break LOOP
+						}
+						if len(receiveData) > 0 {
+							pending[start] = fileDataMsg.Position
+						}
+						for end, ok := pending[confirmed]; ok; end, ok = pending[confirmed] {
+							delete(pending, confirmed)
+							confirmed = end
+						}
+						received += int64(len(receiveData))
+						r.Lock()
+						r.receivedBytes += int64(len(receiveData))
+						r.Unlock()
+						if fileDataMsg.EOF && size < 0 {
+							size = fileDataMsg.Position
+						}
+						r.events.Emit(event.Event{Type: event.BytesTransferred, File: fileInfo.Name, Size: size, Bytes: received})
+						if state != nil && confirmed-state.Position >= resumeSaveInterval && file.Sync() == nil {
+							state.Position = confirmed
+							_ = saveResumeState(pathToFile, state)
+						}
+						if size >= 0 && confirmed >= size {
+							r.events.Emit(event.Event{Type: event.FileCompleted, File: fileInfo.Name, Size: size})
+							if extracted != nil {
+								_ = out.(*io.PipeWriter).Close()
+								r.extracted(pathToFile, fileInfo, <-extracted)
+							} else if file != nil {
+								_ = file.Close()
+								file = nil
+								removeResumeState(pathToFile)
+								verified := r.verifyFile(pathToFile, fileInfo)
+								if verified && r.opt.Extract && fileInfo.Archive == files.ArchiveZip {
+									err = r.extractZip(pathToFile, fileInfo)
+									if err == nil {
+										_ = os.Remove(pathToFile)
+									}
+									r.extracted(pathToFile, fileInfo, err)
+								} else {
+									if verified {
+										restoreAttributes(pathToFile, fileInfo)
+									}
+									r.Lock()
+									r.receivedFiles = append(r.receivedFiles, pathToFile)
+									r.Unlock()
 								}
+							} else {
+								r.Lock()
+								r.receivedFiles = append(r.receivedFiles, pathToFile)
+								r.Unlock()
 							}
+							r.latestFileWriteDone <- true
+							break LOOP
 						}
 					}
 				}
-				r.wg.Done()
-			}()
+			}
+		}
+	}()
+}
+
+// decompress returns the file data of a chunk
+func decompress(fileInfo *files.FileInfo, codec compress.Codec, chunk *message.FileDataPayload) ([]byte, error) {
+	if !chunk.Compressed {
+		return chunk.Data, nil
+	}
+	if codec == nil {
+		return nil, errors.New("compressed chunk of an uncompressed file")
+	}
+	return codec.Decompress(chunk.Data)
+}
+
+// extracted records an archive that failed to be unpacked, the files of
+// the archive are received files already.
+func (r *Receiver) extracted(pathToFile string, fileInfo *files.FileInfo, err error) {
+	if err == nil {
+		return
+	}
+	r.logger.Error(fmt.Sprintf("extract [%s] failed: %s", pathToFile, err))
+	r.events.Emit(event.Event{Type: event.FileFailed, File: fileInfo.Name, Err: err})
+	r.Lock()
+	r.failedFiles = append(r.failedFiles, pathToFile)
+	r.Unlock()
+}
+
+// discardFile removes a file that failed to be received
+func (r *Receiver) discardFile(pathToFile string) {
+	if r.sink != nil {
+		return
+	}
+	_ = os.Remove(pathToFile)
+	removeResumeState(pathToFile)
+}
+
+// skipFile tells the other to skip the file, it's counted as handled
+func (r *Receiver) skipFile(stream transmit.GrpcStream, pathToFile string, fileInfo *files.FileInfo) {
+	r.Lock()
+	r.skippedFiles = append(r.skippedFiles, pathToFile)
+	r.Unlock()
+	r.events.Emit(event.Event{Type: event.FileSkipped, File: fileInfo.Name, Size: fileInfo.Size})
+	// no write of this file to wait for
+	r.writing = false
+	_ = stream.Send(message.NewMessage(proto.MessageType_SkipFile, nil))
+	r.wg.Done()
+}
+
+// linkFile recreates the symlink of the other, the other sends no file data for it
+func (r *Receiver) linkFile(stream transmit.GrpcStream, pathToFile string, fileInfo *files.FileInfo) {
+	if !r.opt.UnsafeSymlinks && !r.insideOutPath(pathToFile, fileInfo.Symlink) {
+		r.logger.Warn(fmt.Sprintf("refuse symlink [%s] pointing outside the receive path: %s", pathToFile, fileInfo.Symlink))
+		r.skipFile(stream, pathToFile, fileInfo)
+		return
+	}
+	if _, err := os.Lstat(pathToFile); err == nil {
+		if target, err := os.Readlink(pathToFile); err == nil && target == fileInfo.Symlink {
+			r.skipFile(stream, pathToFile, fileInfo)
+			return
+		}
+		target, err := r.resolveConflict(pathToFile, fileInfo)
+		if err != nil {
+			r.abort(stream, err)
+			return
+		}
+		if target == "" {
+			r.skipFile(stream, pathToFile, fileInfo)
+			return
+		}
+		if target == pathToFile {
+			_ = os.Remove(pathToFile)
 		}
+		pathToFile = target
+	}
+	err := os.Symlink(fileInfo.Symlink, pathToFile)
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("create symlink [%s] failed: %s", pathToFile, err))
+		r.events.Emit(event.Event{Type: event.FileFailed, File: fileInfo.Name, Err: err})
+		r.Lock()
+		r.failedFiles = append(r.failedFiles, pathToFile)
+		r.Unlock()
+	} else {
+		r.Lock()
+		r.linkedFiles = append(r.linkedFiles, pathToFile)
+		r.Unlock()
+	}
+	r.writing = false
+	_ = stream.Send(message.NewMessage(proto.MessageType_SkipFile, nil))
+	r.wg.Done()
+}
+
+// restoreAttributes gives the received file the permission bits and modification time it has on the other
+func restoreAttributes(pathToFile string, fileInfo *files.FileInfo) {
+	if fileInfo.Mode != 0 {
+		_ = os.Chmod(pathToFile, os.FileMode(fileInfo.Mode).Perm())
+	}
+	if fileInfo.ModTime != 0 {
+		modTime := time.UnixMilli(fileInfo.ModTime)
+		_ = os.Chtimes(pathToFile, modTime, modTime)
+	}
+}
+
+// isSameFile compares size and imohash of the existing file with the incoming one
+func isSameFile(pathToFile string, fileInfo *files.FileInfo) bool {
+	if len(fileInfo.IMOHash) == 0 {
+		return false
+	}
+	stat, err := os.Lstat(pathToFile)
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() != fileInfo.Size {
+		return false
+	}
+	hash, err := tools.IMOHashFile(pathToFile)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(hash, fileInfo.IMOHash)
+}
+
+// verifyFile hashes the received file and compares it with the hash from the other,
+// a file that doesn't match is deleted, or renamed to *.corrupt when quarantine is enabled, and false is returned.
+func (r *Receiver) verifyFile(pathToFile string, fileInfo *files.FileInfo) bool {
+	if len(fileInfo.Hash) == 0 {
+		return true
+	}
+	hash, err := tools.HashFile(pathToFile, fileInfo.HashAlgorithm)
+	if err == nil && bytes.Equal(hash, fileInfo.Hash) {
+		r.Lock()
+		r.verifiedFiles = append(r.verifiedFiles, pathToFile)
+		r.Unlock()
+		r.events.Emit(event.Event{Type: event.FileVerified, File: fileInfo.Name, Size: fileInfo.Size})
+		return true
+	}
+	if err == nil {
+		err = fmt.Errorf("%s mismatch", fileInfo.HashAlgorithm)
 	}
+	r.logger.Error(fmt.Sprintf("verify file [%s] failed: %s", pathToFile, err))
+	r.events.Emit(event.Event{Type: event.FileFailed, File: fileInfo.Name, Size: fileInfo.Size, Err: err})
+	if r.opt.Quarantine {
+		_ = os.Rename(pathToFile, pathToFile+".corrupt")
+	} else {
+		_ = os.Remove(pathToFile)
+	}
+	r.Lock()
+	r.failedFiles = append(r.failedFiles, pathToFile)
+	r.Unlock()
+	return false
+}
+
+// startKeyExchange starts the PAKE keyed by the share code, the relay
+// only sees the public parts of the exchange.
+func (r *Receiver) startKeyExchange(stream transmit.GrpcStream) error {
+	p, err := pake.InitCurve([]byte(r.opt.ShareCode), 0, crypt.Curve)
+	if err != nil {
+		return err
+	}
+	r.pake = p
+	exchange := &message.KeyExchangePayload{
+		Pake: p.Bytes(),
+	}
+	payload, _ := exchange.Bytes(message.JSONProtocol)
+	return stream.Send(message.NewMessage(proto.MessageType_KeyExchange, payload))
+}
 
+// finishKeyExchange derives the key used to decrypt all following payloads
+func (r *Receiver) finishKeyExchange(msg *proto.Message) error {
+	if r.pake == nil {
+		return errors.New("key exchange not started")
+	}
+	pm, err := message.ParseMessagePayload(msg)
+	if err != nil {
+		return err
+	}
+	exchange, ok := pm.(*message.KeyExchangePayload)
+	if !ok || exchange == nil {
+		return errors.New("invalid key exchange payload")
+	}
+	if err = r.pake.Update(exchange.Pake); err != nil {
+		return err
+	}
+	sessionKey, err := r.pake.SessionKey()
+	if err != nil {
+		return err
+	}
+	r.key, _, err = crypt.New(sessionKey, exchange.Salt)
+	return err
 }
 
-func checkOptions(opt *options.ReceiverOptions) {
+func checkOptions(opt *options.ReceiverOptions) error {
 	if tools.IsBlank(opt.ShareCode) {
-		tools.Println(tools.Red, "share code can't empty")
-		os.Exit(1)
+		return errors.New("share code can't empty")
+	}
+	if err := crypt.ValidateCode(opt.ShareCode); err != nil {
+		return fmt.Errorf("invalid share code: %w", err)
 	}
 	if !opt.LocalNetwork && tools.IsBlank(opt.Relay) {
-		tools.Println(tools.Red, "relay address can't empty")
-		os.Exit(1)
+		return errors.New("relay address can't empty")
 	}
-	if opt.LocalNetwork && opt.Relay != common.PublicRelay {
-		tools.Println(tools.Yellow, "you enable local network, relay will disabled")
+	if opt.MaxSize < 0 {
+		return errors.New("max size can't be negative")
 	}
+	return checkConflictPolicy(opt.OnConflict)
 }
 
-func NewReceiver(opt *options.ReceiverOptions) *Receiver {
-	checkOptions(opt)
+// NewReceiver checks the options, logger, observer and prompter may be nil,
+// without a prompter every transfer is accepted and no file overwritten unless the options say so.
+func NewReceiver(opt *options.ReceiverOptions, logger tools.Logger, observer event.Observer, prompter Prompter) (*Receiver, error) {
+	if err := checkOptions(opt); err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = tools.NopLogger
+	}
+	if prompter == nil {
+		prompter = acceptAll{}
+	}
+	if opt.LocalNetwork && opt.Relay != common.PublicRelay {
+		logger.Warn("you enable local network, relay will disabled")
+	}
+	relayTLS, err := client.RelayTLSConfig(opt.RelayCA, opt.RelayTLS)
+	if err != nil {
+		return nil, fmt.Errorf("relay tls error: %w", err)
+	}
 	return &Receiver{
 		opt:                 opt,
+		logger:              logger,
+		events:              event.NewEmitter(observer),
+		prompter:            prompter,
+		relayOpt:            &options.GrpcClientOptions{TLSConfig: relayTLS, Token: opt.RelayToken},
 		fileHandleMsg:       make(chan *proto.Message, 10),
-		done:                make(chan bool, 1),
 		latestFileWriteDone: make(chan bool, 1),
-	}
+		done:                make(chan struct{}),
+	}, nil
 }
@@ -0,0 +1,83 @@
+package receiver
+
+import (
+	"bytes"
+	"github.com/duyunis/pdh/files"
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/options"
+	"github.com/duyunis/pdh/proto"
+	"sync"
+	"testing"
+)
+
+// recordingStream keeps what the receiver sends to the other
+type recordingStream struct {
+	sync.Mutex
+	sent []proto.MessageType
+}
+
+func (s *recordingStream) Send(msg *proto.Message) error {
+	s.Lock()
+	defer s.Unlock()
+	s.sent = append(s.sent, msg.MessageType)
+	return nil
+}
+
+func (s *recordingStream) cancelled() bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, t := range s.sent {
+		if t == proto.MessageType_Cancel {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestReceiver is a receiver after the key exchange, receiving into a temporary folder
+func newTestReceiver(t *testing.T) *Receiver {
+	r, err := NewReceiver(&options.ReceiverOptions{ShareCode: "1a2b-3c4d", Relay: "relay", OutPath: t.TempDir(), Yes: true}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.key = bytes.Repeat([]byte{7}, 32)
+	return r
+}
+
+func encrypted(t *testing.T, key []byte, messageType proto.MessageType, payload message.Message) *proto.Message {
+	data, err := payload.Bytes(message.JSONProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := message.NewEncryptedMessage(messageType, data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestReceiverRefusesMoreFilesThanAnnounced(t *testing.T) {
+	r := newTestReceiver(t)
+	stream := &recordingStream{}
+	r.HandleMessage(stream, encrypted(t, r.key, proto.MessageType_FileStat, &message.FileStatPayload{FilesNumber: 1}))
+	for _, name := range []string{"a", "b", "c"} {
+		link := &files.FileInfo{Name: name, FolderRemote: "./", Symlink: "target", IsEncrypted: true}
+		// a wait group counting below zero panics
+		r.HandleMessage(stream, encrypted(t, r.key, proto.MessageType_FileInfo, &message.FileInfoPayload{FileInfo: link}))
+	}
+	if !stream.cancelled() {
+		t.Errorf("the receiver took %d files of 1 announced without cancelling", r.startedFiles)
+	}
+}
+
+func TestReceiverRefusesImplausibleFileStat(t *testing.T) {
+	for _, number := range []int64{-1, maxFiles + 1} {
+		r := newTestReceiver(t)
+		stream := &recordingStream{}
+		r.HandleMessage(stream, encrypted(t, r.key, proto.MessageType_FileStat, &message.FileStatPayload{FilesNumber: number}))
+		<-r.done
+		if r.err == nil || !stream.cancelled() {
+			t.Errorf("file stat of %d files: err = %v, want the transfer aborted", number, r.err)
+		}
+	}
+}
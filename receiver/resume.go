@@ -0,0 +1,76 @@
+package receiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/duyunis/pdh/files"
+	"os"
+)
+
+const (
+	// resumeSuffix is appended to the path of a file being received to store its transfer state
+	resumeSuffix = ".pdh-resume"
+	// resumeSaveInterval is the number of bytes written between two saves of the transfer state
+	resumeSaveInterval = 4 * 1024 * 1024
+)
+
+// resumeState is the transfer state of a partially received file
+type resumeState struct {
+	Hash     []byte `json:"Hash,omitempty"`
+	Size     int64  `json:"Size,omitempty"`
+	Position int64  `json:"Position,omitempty"`
+}
+
+func loadResumeState(pathToFile string) (*resumeState, error) {
+	data, err := os.ReadFile(pathToFile + resumeSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var state resumeState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// saveResumeState writes the state to a temp file first, so a crash never leaves a broken state behind
+func saveResumeState(pathToFile string, state *resumeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := pathToFile + resumeSuffix + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, pathToFile+resumeSuffix)
+}
+
+func removeResumeState(pathToFile string) {
+	_ = os.Remove(pathToFile + resumeSuffix)
+}
+
+// resumePosition returns the position to continue receiving the file from,
+// 0 if there's no partial file of the same source.
+func resumePosition(pathToFile string, fileInfo *files.FileInfo) int64 {
+	if len(fileInfo.Hash) == 0 {
+		return 0
+	}
+	state, err := loadResumeState(pathToFile)
+	if err != nil {
+		return 0
+	}
+	if !bytes.Equal(state.Hash, fileInfo.Hash) || state.Size != fileInfo.Size {
+		return 0
+	}
+	if state.Position < 0 || state.Position > state.Size {
+		return 0
+	}
+	stat, err := os.Lstat(pathToFile)
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() < state.Position {
+		return 0
+	}
+	return state.Position
+}
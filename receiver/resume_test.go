@@ -0,0 +1,60 @@
+package receiver
+
+import (
+	"github.com/duyunis/pdh/files"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumePosition(t *testing.T) {
+	hash := []byte{1, 2, 3, 4}
+	fileInfo := &files.FileInfo{Hash: hash, Size: 100}
+
+	cases := []struct {
+		name    string
+		state   string
+		partial int
+		want    int64
+	}{
+		{"matching state", `{"Hash":"AQIDBA==","Size":100,"Position":40}`, 40, 40},
+		{"partial file longer than saved", `{"Hash":"AQIDBA==","Size":100,"Position":40}`, 60, 40},
+		{"stale hash", `{"Hash":"BQYHCA==","Size":100,"Position":40}`, 40, 0},
+		{"size mismatch", `{"Hash":"AQIDBA==","Size":99,"Position":40}`, 40, 0},
+		{"truncated file", `{"Hash":"AQIDBA==","Size":100,"Position":40}`, 10, 0},
+		{"no partial file", `{"Hash":"AQIDBA==","Size":100,"Position":40}`, -1, 0},
+		{"position past size", `{"Hash":"AQIDBA==","Size":100,"Position":200}`, 200, 0},
+		{"negative position", `{"Hash":"AQIDBA==","Size":100,"Position":-5}`, 40, 0},
+		{"broken state", `{"Hash":`, 40, 0},
+		{"no state", "", 40, 0},
+	}
+	for _, c := range cases {
+		pathToFile := filepath.Join(t.TempDir(), "file")
+		if c.state != "" {
+			if err := os.WriteFile(pathToFile+resumeSuffix, []byte(c.state), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.partial >= 0 {
+			if err := os.WriteFile(pathToFile, make([]byte, c.partial), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := resumePosition(pathToFile, fileInfo); got != c.want {
+			t.Errorf("%s: resumePosition = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestResumePositionWithoutHash(t *testing.T) {
+	pathToFile := filepath.Join(t.TempDir(), "file")
+	if err := saveResumeState(pathToFile, &resumeState{Size: 100, Position: 40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pathToFile, make([]byte, 40), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := resumePosition(pathToFile, &files.FileInfo{Size: 100}); got != 0 {
+		t.Errorf("resumePosition = %d, want 0 without the hash of the sender", got)
+	}
+}
@@ -0,0 +1,199 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/event"
+	"github.com/duyunis/pdh/files"
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/proto"
+	"github.com/duyunis/pdh/tools"
+	"github.com/duyunis/pdh/transmit"
+	"io"
+	"time"
+)
+
+const (
+	// fetchTimeout is how long to wait for the relay to answer a fetch, older relays never do
+	fetchTimeout = time.Second * 10
+	// storedName names the stored archive in the progress
+	storedName = "stored.tar.zst"
+)
+
+// storedTransfer is the download of a transfer the sender stored on the relay, files arrive
+// as a tar.zst that is extracted while it arrives, or written to the sink as it is.
+type storedTransfer struct {
+	key       []byte
+	text      bool
+	out       io.Writer
+	pipe      *io.PipeWriter
+	extracted chan error
+	position  int64
+	eof       bool
+}
+
+// fetchStored asks the relay for a transfer stored with the share code
+func (r *Receiver) fetchStored(stream transmit.GrpcStream) {
+	r.fetching = true
+	r.fetched = make(chan struct{})
+	err := stream.Send(message.NewMessage(proto.MessageType_Fetch, []byte(crypt.ChannelName(r.opt.ShareCode))))
+	if err != nil {
+		r.finish(fmt.Errorf("stream is error: %w", err))
+		return
+	}
+	go func(fetched chan struct{}) {
+		select {
+		case <-fetched:
+		case <-r.done:
+		case <-time.After(fetchTimeout):
+			r.finish(errors.New("channel not found, please check your share code"))
+		}
+	}(r.fetched)
+}
+
+// receiveStored decrypts the file stat of the stored transfer and asks the relay for the data once accepted
+func (r *Receiver) receiveStored(stream transmit.GrpcStream, msg *proto.Message) {
+	if r.fetched != nil {
+		close(r.fetched)
+		r.fetched = nil
+	}
+	pm, err := message.ParseMessagePayload(msg)
+	if err == nil && pm == nil {
+		err = errors.New("empty stored transfer")
+	}
+	if err != nil {
+		r.finish(fmt.Errorf("fetch stored transfer failed: %w", err))
+		return
+	}
+	stored := pm.(*message.StorePayload)
+	key, _, err := crypt.StoreKey(r.opt.ShareCode, stored.Salt)
+	if err != nil {
+		r.finish(fmt.Errorf("fetch stored transfer failed: %w", err))
+		return
+	}
+	pm, err = message.ParseEncryptedMessagePayload(message.NewMessage(proto.MessageType_FileStat, stored.Manifest), key)
+	if err == nil && pm == nil {
+		err = errors.New("empty file stat")
+	}
+	if err != nil {
+		r.finish(fmt.Errorf("get file stat failed: %w, please check your share code", err))
+		return
+	}
+	stat := pm.(*message.FileStatPayload)
+	r.logger.Info(fmt.Sprintf("Found a transfer stored on the relay until %s", time.Unix(stored.Expires, 0).Format("2006-01-02 15:04:05")))
+	agree := message.NewMessage(proto.MessageType_AgreeReceive, nil)
+
+	if stat.Text != "" {
+		err = r.writeText(stat.Text)
+		if err != nil {
+			r.finish(fmt.Errorf("write text failed: %w", err))
+			return
+		}
+		r.stored = &storedTransfer{text: true}
+		err = stream.Send(agree)
+		if err != nil {
+			r.finish(fmt.Errorf("stream is error: %w", err))
+		}
+		return
+	}
+
+	if r.opt.MaxSize > 0 && stat.FilesSize > r.opt.MaxSize {
+		r.finish(fmt.Errorf("refused %s, the max size is %s", tools.ByteCountDecimal(stat.FilesSize), tools.ByteCountDecimal(r.opt.MaxSize)))
+		return
+	}
+	offer := &Offer{
+		Files:   stat.FilesNumber,
+		Folders: stat.FolderNumber,
+		Size:    stat.FilesSize,
+	}
+	if !r.opt.Yes && !r.prompter.AcceptTransfer(offer) {
+		r.finish(nil)
+		return
+	}
+	r.filesSize = stat.FilesSize
+	r.events.Emit(event.Event{Type: event.TransferStarted, Files: stat.FilesNumber, Size: stat.FilesSize})
+	st := &storedTransfer{key: key, out: r.sink}
+	if r.sink == nil {
+		st.pipe, st.extracted = r.extractTar(&files.FileInfo{Archive: files.ArchiveTarZst})
+		st.out = st.pipe
+	}
+	r.stored = st
+	err = stream.Send(agree)
+	if err != nil {
+		r.failStored(fmt.Errorf("stream is error: %w", err))
+		return
+	}
+	r.logger.Info("")
+	r.logger.Info("Receiving...")
+	r.logger.Info("")
+	r.events.Emit(event.Event{Type: event.FileStarted, File: storedName, Size: -1})
+}
+
+// writeStored writes a chunk of the stored archive, the chunks arrive in order
+func (r *Receiver) writeStored(msg *proto.Message) {
+	st := r.stored
+	if st == nil || st.text || st.eof {
+		r.finish(errors.New("unexpected stored data"))
+		return
+	}
+	pm, err := message.ParseEncryptedMessagePayload(message.NewMessage(proto.MessageType_FileData, msg.Payload), st.key)
+	if err == nil && pm == nil {
+		err = errors.New("empty chunk")
+	}
+	if err != nil {
+		r.failStored(fmt.Errorf("read stored data failed: %w", err))
+		return
+	}
+	chunk := pm.(*message.FileDataPayload)
+	if chunk.Position != st.position+int64(len(chunk.Data)) {
+		r.failStored(errors.New("stored data is out of order"))
+		return
+	}
+	_, err = st.out.Write(chunk.Data)
+	if err != nil {
+		r.failStored(fmt.Errorf("write stored data failed: %w", err))
+		return
+	}
+	st.position = chunk.Position
+	r.Lock()
+	r.receivedBytes += int64(len(chunk.Data))
+	r.Unlock()
+	size := int64(-1)
+	if chunk.EOF {
+		size = st.position
+	}
+	r.events.Emit(event.Event{Type: event.BytesTransferred, File: storedName, Size: size, Bytes: st.position})
+	if !chunk.EOF {
+		return
+	}
+	st.eof = true
+	if st.pipe != nil {
+		_ = st.pipe.Close()
+		if err = <-st.extracted; err != nil {
+			r.finish(fmt.Errorf("extract failed: %w", err))
+			return
+		}
+	}
+	r.events.Emit(event.Event{Type: event.FileCompleted, File: storedName, Size: st.position})
+}
+
+// finishStored ends the download once the relay sent all stored data
+func (r *Receiver) finishStored() {
+	st := r.stored
+	if st == nil {
+		return
+	}
+	if !st.text && !st.eof {
+		r.failStored(errors.New("the stored transfer is incomplete"))
+		return
+	}
+	r.finish(r.failed())
+}
+
+func (r *Receiver) failStored(err error) {
+	if st := r.stored; st != nil && st.pipe != nil {
+		_ = st.pipe.CloseWithError(err)
+	}
+	r.finish(err)
+}
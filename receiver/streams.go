@@ -0,0 +1,100 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/options"
+	"github.com/duyunis/pdh/proto"
+	"github.com/duyunis/pdh/transmit"
+	"github.com/duyunis/pdh/transmit/client"
+	"time"
+)
+
+// dataStreamTimeout is how long to wait for joining a data channel on the relay
+const dataStreamTimeout = time.Second * 10
+
+// dataStream handles an extra stream to the other that only carries file data
+type dataStream struct {
+	r      *Receiver
+	index  int
+	joined chan error
+}
+
+func (d *dataStream) HandleMessage(stream transmit.GrpcStream, msg *proto.Message) {
+	switch msg.MessageType {
+	case proto.MessageType_JoinChannelSuccess:
+		d.joined <- d.r.registerDataStream(stream, d.index)
+	case proto.MessageType_ChannelNotFound, proto.MessageType_ChannelFull, proto.MessageType_JoinChannelFailed, proto.MessageType_Unauthorized, proto.MessageType_TooManyJoins:
+		d.joined <- errors.New("join data channel failed")
+	case proto.MessageType_ChannelQuotaExceeded:
+		d.r.HandleMessage(stream, msg)
+	case proto.MessageType_FileData:
+		select {
+		case d.r.fileHandleMsg <- msg:
+		case <-d.r.done:
+		}
+	}
+}
+
+// registerDataStream tells the other the stream is the data stream index
+func (r *Receiver) registerDataStream(stream transmit.GrpcStream, index int) error {
+	ds := &message.DataStreamPayload{Index: int64(index)}
+	payload, _ := ds.Bytes(message.JSONProtocol)
+	dsm, err := message.NewEncryptedMessage(proto.MessageType_DataStream, payload, r.key)
+	if err != nil {
+		return err
+	}
+	return stream.Send(dsm)
+}
+
+// openDataStreams opens the extra data streams the other offered,
+// returns the number of streams including the main one.
+func (r *Receiver) openDataStreams(count int) int {
+	opened := 1
+	for i := 1; i < count; i++ {
+		ds := &dataStream{
+			r:      r,
+			index:  i,
+			joined: make(chan error, 1),
+		}
+		var gc *client.GrpcClient
+		if r.localAddress != "" {
+			r.RLock()
+			gc = client.NewPdhGrpcClientWithOptions(r.localAddress, &options.GrpcClientOptions{
+				TLSConfig: client.PinnedTLSConfig(r.localFingerprint, nil),
+			})
+			r.RUnlock()
+		} else {
+			gc = client.NewPdhGrpcClientWithOptions(r.opt.Relay, r.relayOpt)
+		}
+		gc.AddHandler(ds)
+		err := gc.Start()
+		if err == nil {
+			if r.localAddress != "" {
+				// connected to the other directly
+				err = r.registerDataStream(gc, i)
+			} else {
+				err = gc.Send(message.NewMessage(proto.MessageType_JoinChannel, []byte(crypt.DataChannelName(r.opt.ShareCode, i))))
+				if err == nil {
+					select {
+					case err = <-ds.joined:
+					case <-time.After(dataStreamTimeout):
+						err = errors.New("join data channel timeout")
+					}
+				}
+			}
+		}
+		if err != nil {
+			r.logger.Warn(fmt.Sprintf("open data stream error: %s", err))
+			gc.Stop()
+			continue
+		}
+		r.Lock()
+		r.dataClients = append(r.dataClients, gc)
+		r.Unlock()
+		opened++
+	}
+	return opened
+}
@@ -0,0 +1,65 @@
+package relay
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"github.com/duyunis/pdh/common"
+	"github.com/duyunis/pdh/transmit"
+	"google.golang.org/grpc/metadata"
+	"os"
+	"strings"
+)
+
+// loadTokens reads the access tokens by their name from the auth file,
+// each line is name:token, empty lines and lines starting with # are ignored.
+func loadTokens(authFile string) (map[string]string, error) {
+	file, err := os.Open(authFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, token, ok := strings.Cut(line, ":")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("%s:%d: want name:token", authFile, n)
+		}
+		if _, ok = tokens[name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate name %s", authFile, n, name)
+		}
+		tokens[name] = token
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no token", authFile)
+	}
+	return tokens, nil
+}
+
+// authorize returns the name of the token the stream was opened with, false if it's not a token of the relay
+func (r *Relay) authorize(stream transmit.GrpcStream) (string, bool) {
+	sw, ok := stream.(*transmit.ServerStreamWrapper)
+	if !ok {
+		return "", false
+	}
+	md, _ := metadata.FromIncomingContext(sw.Stream.Context())
+	values := md.Get(common.TokenMetadataKey)
+	if len(values) == 0 {
+		return "", false
+	}
+	for name, token := range r.tokens {
+		if subtle.ConstantTimeCompare([]byte(values[0]), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
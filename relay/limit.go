@@ -0,0 +1,116 @@
+package relay
+
+import (
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/metrics"
+	"github.com/duyunis/pdh/proto"
+	"github.com/duyunis/pdh/transmit"
+	"github.com/duyunis/pdh/transmit/pipe"
+	"google.golang.org/grpc/peer"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// joinWindow counts the joins of a client address in the minute since start
+type joinWindow struct {
+	start time.Time
+	count int
+}
+
+// joinLimiter bounds the joins of each client address per minute, guessing share codes takes many
+type joinLimiter struct {
+	sync.Mutex
+	max     int
+	windows map[string]*joinWindow
+}
+
+// allow counts a join of the address, false if it's one too many
+func (l *joinLimiter) allow(ip string) bool {
+	if l.max <= 0 {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	w, ok := l.windows[ip]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		w = &joinWindow{start: now}
+		l.windows[ip] = w
+	}
+	w.count++
+	return w.count <= l.max
+}
+
+// expire forgets the addresses whose minute ended
+func (l *joinLimiter) expire() {
+	l.Lock()
+	defer l.Unlock()
+	for ip, w := range l.windows {
+		if time.Since(w.start) >= time.Minute {
+			delete(l.windows, ip)
+		}
+	}
+}
+
+// clientIP is the address of the client of the stream without its port, empty if unknown
+func clientIP(stream transmit.GrpcStream) string {
+	sw, ok := stream.(*transmit.ServerStreamWrapper)
+	if !ok {
+		return ""
+	}
+	p, ok := peer.FromContext(sw.Stream.Context())
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// allowJoin counts a join or fetch of the client of the stream, and tells it when it tried too many
+func (r *Relay) allowJoin(stream transmit.GrpcStream) bool {
+	ip := clientIP(stream)
+	if r.joins.allow(ip) {
+		return true
+	}
+	log.Printf("join refused: too many joins from %s\n", ip)
+	metrics.Rejected.WithLabelValues(metrics.RejectedTooManyJoins).Inc()
+	_ = stream.Send(message.NewMessage(proto.MessageType_TooManyJoins, nil))
+	return false
+}
+
+// allowChannel checks the relay and the client have room for another channel, the lock must be held
+func (r *Relay) allowChannel(stream transmit.GrpcStream, ip string) bool {
+	if r.options.MaxChannels > 0 && len(r.channels)+r.storeSessions >= r.options.MaxChannels {
+		log.Println("create channel refused: the relay is full")
+		metrics.Rejected.WithLabelValues(metrics.RejectedRelayFull).Inc()
+		_ = stream.Send(message.NewMessage(proto.MessageType_RelayFull, nil))
+		return false
+	}
+	if r.options.MaxChannelsPerIP > 0 && r.channelsByIP[ip] >= r.options.MaxChannelsPerIP {
+		log.Printf("create channel refused: too many channels of %s\n", ip)
+		metrics.Rejected.WithLabelValues(metrics.RejectedTooManyChannels).Inc()
+		_ = stream.Send(message.NewMessage(proto.MessageType_TooManyChannels, nil))
+		return false
+	}
+	return true
+}
+
+// channelLimit is the limit of a new channel, the data channels of a share code share
+// the limit of its channel, the lock must be held.
+func (r *Relay) channelLimit(name string) *pipe.Limit {
+	if r.options.MaxChannelBytes <= 0 && r.options.MaxChannelRate <= 0 {
+		return nil
+	}
+	if base, _, ok := strings.Cut(name, "."); ok {
+		if ch := r.channels[base]; ch != nil {
+			return ch.limit
+		}
+	}
+	return &pipe.Limit{MaxBytes: r.options.MaxChannelBytes, Rate: r.options.MaxChannelRate}
+}
@@ -0,0 +1,117 @@
+package relay
+
+import (
+	"github.com/duyunis/pdh/options"
+	"github.com/duyunis/pdh/proto"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingStream keeps the messages the relay sends to a client
+type recordingStream struct {
+	sync.Mutex
+	sent []*proto.Message
+}
+
+func (s *recordingStream) Send(msg *proto.Message) error {
+	s.Lock()
+	defer s.Unlock()
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func (s *recordingStream) last() proto.MessageType {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.sent) == 0 {
+		return -1
+	}
+	return s.sent[len(s.sent)-1].MessageType
+}
+
+func TestJoinLimiter(t *testing.T) {
+	l := &joinLimiter{max: 2, windows: make(map[string]*joinWindow)}
+	for i, want := range []bool{true, true, false, false} {
+		if got := l.allow("10.0.0.1"); got != want {
+			t.Errorf("join %d of 10.0.0.1 allowed = %v, want %v", i+1, got, want)
+		}
+	}
+	if !l.allow("10.0.0.2") {
+		t.Error("another address was refused")
+	}
+
+	// the minute of 10.0.0.1 ends
+	l.windows["10.0.0.1"].start = time.Now().Add(-time.Minute)
+	if !l.allow("10.0.0.1") {
+		t.Error("10.0.0.1 was refused after its minute ended")
+	}
+	l.windows["10.0.0.2"].start = time.Now().Add(-time.Minute)
+	l.expire()
+	if _, ok := l.windows["10.0.0.2"]; ok {
+		t.Error("expire kept an address whose minute ended")
+	}
+	if _, ok := l.windows["10.0.0.1"]; !ok {
+		t.Error("expire dropped an address within its minute")
+	}
+
+	unlimited := &joinLimiter{windows: make(map[string]*joinWindow)}
+	for i := 0; i < 1000; i++ {
+		if !unlimited.allow("10.0.0.1") {
+			t.Fatal("a limiter without max refused a join")
+		}
+	}
+}
+
+func TestAllowChannel(t *testing.T) {
+	r := &Relay{
+		options:      &options.RelayOptions{MaxChannels: 3, MaxChannelsPerIP: 2},
+		channels:     make(map[string]*channel),
+		channelsByIP: make(map[string]int),
+	}
+	stream := &recordingStream{}
+	if !r.allowChannel(stream, "10.0.0.1") {
+		t.Fatal("the first channel was refused")
+	}
+
+	r.channels["a"] = &channel{ip: "10.0.0.1"}
+	r.channelsByIP["10.0.0.1"] = 2
+	if r.allowChannel(stream, "10.0.0.1") || stream.last() != proto.MessageType_TooManyChannels {
+		t.Errorf("a third channel of one address was allowed, last sent %v", stream.last())
+	}
+	if !r.allowChannel(stream, "10.0.0.2") {
+		t.Error("another address was refused")
+	}
+
+	// uploads and downloads of the store count as channels
+	r.channels["b"] = &channel{ip: "10.0.0.2"}
+	r.storeSessions = 1
+	if r.allowChannel(stream, "10.0.0.3") || stream.last() != proto.MessageType_RelayFull {
+		t.Errorf("a channel past the max of the relay was allowed, last sent %v", stream.last())
+	}
+
+	unlimited := &Relay{options: &options.RelayOptions{}, channels: r.channels, channelsByIP: r.channelsByIP}
+	if !unlimited.allowChannel(stream, "10.0.0.1") {
+		t.Error("a relay without limits refused a channel")
+	}
+}
+
+func TestChannelLimit(t *testing.T) {
+	r := &Relay{options: &options.RelayOptions{}, channels: make(map[string]*channel)}
+	if r.channelLimit("a") != nil {
+		t.Error("a relay without limits limits a channel")
+	}
+
+	r.options = &options.RelayOptions{MaxChannelBytes: 100, MaxChannelRate: 10}
+	limit := r.channelLimit("a")
+	if limit == nil || limit.MaxBytes != 100 || limit.Rate != 10 {
+		t.Fatalf("channelLimit = %+v, want 100 bytes at 10 per second", limit)
+	}
+	r.channels["a"] = &channel{limit: limit}
+	if r.channelLimit("a.1") != limit {
+		t.Error("a data channel doesn't share the limit of its channel")
+	}
+	if r.channelLimit("b") == limit || r.channelLimit("b.1") == limit {
+		t.Error("another channel shares the limit")
+	}
+}
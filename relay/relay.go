@@ -1,8 +1,11 @@
 package relay
 
 import (
+	"crypto/tls"
+	"errors"
 	"github.com/duyunis/pdh/common"
 	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/metrics"
 	"github.com/duyunis/pdh/options"
 	"github.com/duyunis/pdh/proto"
 	"github.com/duyunis/pdh/transmit"
@@ -17,6 +20,15 @@ type Relay struct {
 	options    *options.RelayOptions
 	grpcServer *server.GrpcServer
 	channels   map[string]*channel
+	// tokens are the access tokens by their name, nil if the relay is open to anyone
+	tokens map[string]string
+	// store keeps transfers for receivers that come later, nil if the relay doesn't store
+	store *store
+	// channelsByIP counts the open channels of each client address
+	channelsByIP map[string]int
+	// storeSessions counts the uploads and downloads of the store, they count as channels
+	storeSessions int
+	joins         *joinLimiter
 }
 
 type channel struct {
@@ -25,24 +37,43 @@ type channel struct {
 	createdAt time.Time
 	full      bool
 	pipe      *pipe.Pipe
+	// receivers the owner allows, a channel of several forwards through hub instead of pipe
+	receivers int64
+	hub       *pipe.Hub
+	// ip is the address of the owner, limit bounds what the channel forwards
+	ip    string
+	limit *pipe.Limit
 }
 
 func (r *Relay) Run() error {
+	go r.serveMetrics()
 	go r.checkChannel()
 	return r.grpcServer.Start()
 }
 
 func (r *Relay) RunAsync() {
+	go r.serveMetrics()
 	go r.checkChannel()
 	go r.grpcServer.Start()
 }
 
+func (r *Relay) serveMetrics() {
+	if r.options.MetricsAddress == "" {
+		return
+	}
+	err := metrics.Serve(r.options.MetricsAddress)
+	if err != nil {
+		log.Printf("serve metrics error: %s\n", err)
+	}
+}
+
 // checkChannel timeout and not connected will delete
 func (r *Relay) checkChannel() {
 	ticker := time.NewTicker(time.Second * 3)
 	for {
 		select {
 		case <-ticker.C:
+			r.Lock()
 			for key := range r.channels {
 				ch := r.channels[key]
 				if ch == nil {
@@ -51,37 +82,77 @@ func (r *Relay) checkChannel() {
 				}
 				err := ch.owner.Send(&proto.Message{MessageType: proto.MessageType_Ping})
 				if err != nil {
-					if ch.pipe != nil {
-						ch.pipe.Stop()
-					}
-					delete(r.channels, key)
+					r.removeChannel(key)
 					continue
 				}
 				if ch.visitor != nil {
 					err = ch.visitor.Send(&proto.Message{MessageType: proto.MessageType_Ping})
 					if err != nil {
-						if ch.pipe != nil {
-							ch.pipe.Stop()
-						}
-						delete(r.channels, key)
+						r.removeChannel(key)
 						continue
 					}
 				}
+				if ch.hub != nil {
+					// a gone visitor only ends its own transfer
+					for _, id := range ch.hub.Peers() {
+						visitor := ch.hub.Visitor(id)
+						if visitor != nil && visitor.Send(&proto.Message{MessageType: proto.MessageType_Ping}) != nil {
+							ch.hub.Leave(id)
+						}
+					}
+				}
 				if time.Since(ch.createdAt) > time.Minute*30 {
-					ch.pipe.Stop()
-					delete(r.channels, key)
+					r.removeChannel(key)
 					continue
 				}
 			}
+			metrics.ChannelsOpen.Set(float64(len(r.channels)))
+			r.Unlock()
+			r.joins.expire()
+			if r.store != nil {
+				r.store.expire()
+			}
 		}
 	}
 }
 
+// removeChannel stops the pipe of the channel and deletes it, the lock must be held
+func (r *Relay) removeChannel(key string) {
+	ch := r.channels[key]
+	if ch.pipe != nil {
+		ch.pipe.Stop()
+	}
+	if ch.hub != nil {
+		ch.hub.Stop()
+	}
+	delete(r.channels, key)
+	if r.channelsByIP[ch.ip]--; r.channelsByIP[ch.ip] <= 0 {
+		delete(r.channelsByIP, ch.ip)
+	}
+	metrics.ChannelLifetime.Observe(time.Since(ch.createdAt).Seconds())
+}
+
 func (r *Relay) HandleMessage(stream transmit.GrpcStream, msg *proto.Message) {
+	switch msg.MessageType {
+	case proto.MessageType_Store, proto.MessageType_StoreData, proto.MessageType_StoreFinish,
+		proto.MessageType_Fetch, proto.MessageType_AgreeReceive:
+		// the store has its own lock, writing to disk doesn't hold up the channels
+		r.handleStore(stream, msg)
+		return
+	}
 	r.Lock()
 	defer r.Unlock()
 	switch msg.MessageType {
 	case proto.MessageType_CreateChannel:
+		if r.tokens != nil {
+			name, ok := r.authorize(stream)
+			if !ok {
+				log.Println("create channel refused: invalid token")
+				_ = stream.Send(message.NewMessage(proto.MessageType_Unauthorized, nil))
+				return
+			}
+			log.Printf("create channel with token %s\n", name)
+		}
 		parseMsg, err := message.ParseMessagePayload(msg)
 		if err != nil {
 			log.Printf("parse message error: %s\n", err)
@@ -89,21 +160,39 @@ func (r *Relay) HandleMessage(stream transmit.GrpcStream, msg *proto.Message) {
 		}
 		channelMsg := parseMsg.(*message.ShareCodePayload)
 		shareCode := channelMsg.ShareCode
-		if len(shareCode) > 0 {
+		if len(shareCode) > 0 && msg.Receivers <= common.MaxReceivers {
 			_, ok := r.channels[shareCode]
+			ip := clientIP(stream)
 			if ok {
 				_ = stream.Send(message.NewMessage(proto.MessageType_CreateChannelFailed, nil))
-			} else {
+			} else if r.allowChannel(stream, ip) {
 				r.channels[shareCode] = &channel{
 					owner:     stream.(*transmit.ServerStreamWrapper),
 					createdAt: time.Now(),
+					receivers: msg.Receivers,
+					ip:        ip,
+					limit:     r.channelLimit(shareCode),
 				}
+				r.channelsByIP[ip]++
+				metrics.ChannelsCreated.Inc()
+				metrics.ChannelsOpen.Set(float64(len(r.channels)))
 				_ = stream.Send(message.NewMessage(proto.MessageType_CreateChannelSuccess, nil))
 			}
 		} else {
 			_ = stream.Send(message.NewMessage(proto.MessageType_CreateChannelFailed, nil))
 		}
 	case proto.MessageType_JoinChannel:
+		if r.tokens != nil && r.options.AuthJoin {
+			if _, ok := r.authorize(stream); !ok {
+				log.Println("join channel refused: invalid token")
+				metrics.Joins.WithLabelValues(metrics.JoinUnauthorized).Inc()
+				_ = stream.Send(message.NewMessage(proto.MessageType_Unauthorized, nil))
+				return
+			}
+		}
+		if !r.allowJoin(stream) {
+			return
+		}
 		parseMsg, err := message.ParseMessagePayload(msg)
 		if err != nil {
 			log.Printf("parse message error: %s\n", err)
@@ -113,39 +202,98 @@ func (r *Relay) HandleMessage(stream transmit.GrpcStream, msg *proto.Message) {
 		shareCode := channelMsg.ShareCode
 		if len(shareCode) > 0 {
 			ch, ok := r.channels[shareCode]
-			if ok {
+			if ok && ch.receivers > 1 {
+				r.joinHub(ch, stream.(*transmit.ServerStreamWrapper))
+			} else if ok {
 				if ch.full {
+					metrics.Joins.WithLabelValues(metrics.JoinFull).Inc()
 					_ = stream.Send(message.NewMessage(proto.MessageType_ChannelFull, nil))
 				} else {
+					ch.full = true
 					ch.visitor = stream.(*transmit.ServerStreamWrapper)
 					// create pipe
-					ch.pipe = pipe.CreatePipe(ch.owner, ch.visitor)
+					ch.pipe = pipe.CreatePipe(ch.owner, ch.visitor, ch.limit)
 					ch.pipe.Start()
 
+					metrics.Joins.WithLabelValues(metrics.JoinSuccess).Inc()
 					_ = stream.Send(message.NewMessage(proto.MessageType_JoinChannelSuccess, nil))
 				}
 			} else {
+				metrics.Joins.WithLabelValues(metrics.JoinNotFound).Inc()
 				_ = stream.Send(message.NewMessage(proto.MessageType_ChannelNotFound, nil))
 			}
 		} else {
+			metrics.Joins.WithLabelValues(metrics.JoinFailed).Inc()
 			_ = stream.Send(message.NewMessage(proto.MessageType_JoinChannelFailed, nil))
 		}
 
 	}
 }
 
+// joinHub adds a visitor to a channel of several receivers, the lock must be held
+func (r *Relay) joinHub(ch *channel, visitor *transmit.ServerStreamWrapper) {
+	if ch.hub == nil {
+		ch.hub = pipe.CreateHub(ch.owner, ch.limit)
+		ch.hub.Start()
+	}
+	if ch.hub.Joined() >= ch.receivers {
+		metrics.Joins.WithLabelValues(metrics.JoinFull).Inc()
+		_ = visitor.Send(message.NewMessage(proto.MessageType_ChannelFull, nil))
+		return
+	}
+	ch.hub.Join(visitor)
+	metrics.Joins.WithLabelValues(metrics.JoinSuccess).Inc()
+	_ = visitor.Send(message.NewMessage(proto.MessageType_JoinChannelSuccess, nil))
+}
+
 func (r *Relay) SendMessage(stream proto.PdhService_TransmitServer, msg *proto.Message) error {
 	return stream.Send(msg)
 }
 
-func NewRelay(opt *options.RelayOptions) *Relay {
-	grpcServer := server.NewPdhGrpcServer(&options.GrpcServerOptions{Address: opt.RelayHost, Ports: opt.RelayPort})
+func NewRelay(opt *options.RelayOptions) (*Relay, error) {
+	serverOpt := &options.GrpcServerOptions{Address: opt.RelayHost, Ports: opt.RelayPort}
+	if opt.TLSCert != "" || opt.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(opt.TLSCert, opt.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		serverOpt.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+	var tokens map[string]string
+	if opt.AuthFile != "" {
+		var err error
+		tokens, err = loadTokens(opt.AuthFile)
+		if err != nil {
+			return nil, err
+		}
+	} else if opt.AuthJoin {
+		return nil, errors.New("--auth-join needs --auth-file")
+	}
+	var st *store
+	if opt.StoreDir != "" {
+		var err error
+		st, err = newStore(opt.StoreDir, opt.StoreQuota, opt.StoreMaxAge)
+		if err != nil {
+			return nil, err
+		}
+	}
+	grpcServer := server.NewPdhGrpcServer(serverOpt)
 	relay := &Relay{
-		options:    opt,
-		channels:   make(map[string]*channel, 0),
-		grpcServer: grpcServer,
+		store:        st,
+		tokens:       tokens,
+		options:      opt,
+		channels:     make(map[string]*channel, 0),
+		channelsByIP: make(map[string]int),
+		joins:        &joinLimiter{max: opt.MaxJoinsPerMinute, windows: make(map[string]*joinWindow)},
+		grpcServer:   grpcServer,
+	}
+	if st != nil {
+		st.release = relay.closeStoreSession
 	}
 	// add message handler
 	grpcServer.AddHandler(relay)
-	return relay
+	return relay, nil
 }
@@ -0,0 +1,564 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/metrics"
+	"github.com/duyunis/pdh/proto"
+	"github.com/duyunis/pdh/transmit"
+	"github.com/duyunis/pdh/transmit/pipe"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// storeIdleTimeout aborts an upload the sender stopped sending
+	storeIdleTimeout = time.Minute
+	// fetchTimeout forgets a receiver that fetched a transfer but never accepted it
+	fetchTimeout = time.Hour
+)
+
+// store keeps the transfers senders uploaded on disk until they expire or were downloaded
+// often enough. A transfer is a .data file of length prefixed records, as the sender sent
+// them, and a .json file of its message.StorePayload.
+type store struct {
+	sync.Mutex
+	dir    string
+	quota  int64
+	maxAge time.Duration
+	// used counts the bytes of stored transfers and uploads
+	used    int64
+	items   map[string]*storedItem
+	uploads map[transmit.GrpcStream]*upload
+	// fetches are the receivers asked to accept a transfer, by stream
+	fetches map[transmit.GrpcStream]*pendingFetch
+	// release ends the session of an upload, it takes the relay lock while the store lock is held
+	release func(*storeSession)
+}
+
+// storeSession is an upload or download of the store, it counts like a channel of its client
+type storeSession struct {
+	ip    string
+	limit *pipe.Limit
+}
+
+type pendingFetch struct {
+	channel string
+	at      time.Time
+}
+
+type storedItem struct {
+	message.StorePayload
+	downloading int64
+}
+
+type upload struct {
+	item       *storedItem
+	file       *os.File
+	writer     *bufio.Writer
+	lastActive time.Time
+	session    *storeSession
+}
+
+// newStore loads the transfers stored in dir, expired ones and aborted uploads are removed
+func newStore(dir string, quota int64, maxAge time.Duration) (*store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	st := &store{
+		dir:     dir,
+		quota:   quota,
+		maxAge:  maxAge,
+		items:   make(map[string]*storedItem),
+		uploads: make(map[transmit.GrpcStream]*upload),
+		fetches: make(map[transmit.GrpcStream]*pendingFetch),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		channel := strings.TrimSuffix(name, filepath.Ext(name))
+		switch filepath.Ext(name) {
+		case ".part":
+			_ = os.Remove(filepath.Join(dir, name))
+		case ".data":
+			if _, err := os.Stat(st.path(channel, ".json")); err != nil {
+				_ = os.Remove(filepath.Join(dir, name))
+			}
+		case ".json":
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			item := &storedItem{}
+			if err == nil {
+				err = json.Unmarshal(data, &item.StorePayload)
+			}
+			if err == nil {
+				_, err = os.Stat(st.path(channel, ".data"))
+			}
+			if err != nil || item.Channel != channel || time.Now().Unix() >= item.Expires {
+				st.remove(channel)
+				continue
+			}
+			st.items[channel] = item
+			st.used += item.Size
+		}
+	}
+	st.updateMetrics()
+	return st, nil
+}
+
+func (st *store) path(channel string, ext string) string {
+	return filepath.Join(st.dir, channel+ext)
+}
+
+// remove deletes the files of a stored transfer
+func (st *store) remove(channel string) {
+	_ = os.Remove(st.path(channel, ".json"))
+	_ = os.Remove(st.path(channel, ".data"))
+}
+
+func (st *store) updateMetrics() {
+	metrics.StoredTransfers.Set(float64(len(st.items)))
+	metrics.StoredBytes.Set(float64(st.used))
+}
+
+// validChannel keeps channel names to the hex the clients derive from share codes, they name files
+func validChannel(channel string) bool {
+	return len(channel) == 32 && strings.Trim(channel, "0123456789abcdef") == ""
+}
+
+// begin starts the upload of the sender on stream, the upload ends the session once it's done
+func (st *store) begin(stream transmit.GrpcStream, p *message.StorePayload, session *storeSession) error {
+	if !validChannel(p.Channel) {
+		return errors.New("invalid channel")
+	}
+	st.Lock()
+	defer st.Unlock()
+	if _, ok := st.items[p.Channel]; ok {
+		return errors.New("a transfer is stored with the share code already")
+	}
+	for _, u := range st.uploads {
+		if u.item.Channel == p.Channel {
+			return errors.New("a transfer is stored with the share code already")
+		}
+	}
+	if _, ok := st.uploads[stream]; ok {
+		return errors.New("upload started already")
+	}
+	item := &storedItem{StorePayload: message.StorePayload{
+		Channel:      p.Channel,
+		Salt:         p.Salt,
+		Manifest:     p.Manifest,
+		MaxAge:       p.MaxAge,
+		MaxDownloads: p.MaxDownloads,
+	}}
+	if item.MaxAge <= 0 || time.Duration(item.MaxAge)*time.Second > st.maxAge {
+		item.MaxAge = int64(st.maxAge / time.Second)
+	}
+	if item.MaxDownloads < 1 {
+		item.MaxDownloads = 1
+	}
+	file, err := os.OpenFile(st.path(p.Channel, ".part"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	st.uploads[stream] = &upload{item: item, file: file, writer: bufio.NewWriter(file), lastActive: time.Now(), session: session}
+	return nil
+}
+
+// write adds a record to the upload of stream, it returns how long to wait before the next
+// record to keep the rate of the session.
+func (st *store) write(stream transmit.GrpcStream, data []byte) (time.Duration, error) {
+	st.Lock()
+	defer st.Unlock()
+	u, ok := st.uploads[stream]
+	if !ok {
+		return 0, errors.New("no upload started")
+	}
+	if len(data) > maxRecordSize {
+		st.abortLocked(stream)
+		return 0, errors.New("record is too large")
+	}
+	size := int64(binary.MaxVarintLen64 + len(data))
+	if st.used+size > st.quota {
+		st.abortLocked(stream)
+		return 0, errors.New("the storage of the relay is full")
+	}
+	wait, err := u.session.limit.Take(len(data))
+	if err != nil {
+		st.abortLocked(stream)
+		return 0, err
+	}
+	header := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(header, uint64(len(data)))
+	_, err = u.writer.Write(header[:n])
+	if err == nil {
+		_, err = u.writer.Write(data)
+	}
+	if err != nil {
+		st.abortLocked(stream)
+		return 0, err
+	}
+	written := int64(n + len(data))
+	u.item.Size += written
+	st.used += written
+	u.lastActive = time.Now()
+	return wait, nil
+}
+
+// finish stores the upload of stream, it can be downloaded from now on
+func (st *store) finish(stream transmit.GrpcStream) (*message.StorePayload, error) {
+	st.Lock()
+	defer st.Unlock()
+	u, ok := st.uploads[stream]
+	if !ok {
+		return nil, errors.New("no upload started")
+	}
+	err := u.writer.Flush()
+	if err == nil {
+		err = u.file.Sync()
+	}
+	if closeErr := u.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(st.path(u.item.Channel, ".part"), st.path(u.item.Channel, ".data"))
+	}
+	if err == nil {
+		u.item.Expires = time.Now().Unix() + u.item.MaxAge
+		err = st.save(u.item)
+	}
+	delete(st.uploads, stream)
+	st.release(u.session)
+	if err != nil {
+		st.used -= u.item.Size
+		_ = os.Remove(st.path(u.item.Channel, ".part"))
+		st.remove(u.item.Channel)
+		return nil, err
+	}
+	st.items[u.item.Channel] = u.item
+	st.updateMetrics()
+	stored := u.item.StorePayload
+	return &stored, nil
+}
+
+// abort drops the upload of stream, if any
+func (st *store) abort(stream transmit.GrpcStream) {
+	st.Lock()
+	defer st.Unlock()
+	st.abortLocked(stream)
+}
+
+func (st *store) abortLocked(stream transmit.GrpcStream) {
+	u, ok := st.uploads[stream]
+	if !ok {
+		return
+	}
+	_ = u.file.Close()
+	_ = os.Remove(st.path(u.item.Channel, ".part"))
+	st.used -= u.item.Size
+	delete(st.uploads, stream)
+	st.release(u.session)
+}
+
+// save writes the metadata of a stored transfer, the lock must be held
+func (st *store) save(item *storedItem) error {
+	data, err := json.Marshal(&item.StorePayload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(st.path(item.Channel, ".json"), data, 0600)
+}
+
+// expire deletes the transfers past their time and drops the uploads the senders left
+func (st *store) expire() {
+	st.Lock()
+	defer st.Unlock()
+	now := time.Now()
+	for channel, item := range st.items {
+		if now.Unix() >= item.Expires {
+			st.deleteLocked(channel)
+			log.Printf("stored transfer %s expired\n", channel)
+		}
+	}
+	for stream, u := range st.uploads {
+		if now.Sub(u.lastActive) > storeIdleTimeout {
+			st.abortLocked(stream)
+		}
+	}
+	for stream, f := range st.fetches {
+		if now.Sub(f.at) > fetchTimeout {
+			delete(st.fetches, stream)
+		}
+	}
+	st.updateMetrics()
+}
+
+func (st *store) deleteLocked(channel string) {
+	item, ok := st.items[channel]
+	if !ok {
+		return
+	}
+	st.used -= item.Size
+	delete(st.items, channel)
+	st.remove(channel)
+}
+
+// fetch returns the stored transfer of the channel if it can be downloaded
+func (st *store) fetch(channel string) (*message.StorePayload, error) {
+	st.Lock()
+	defer st.Unlock()
+	item, ok := st.items[channel]
+	if !ok || time.Now().Unix() >= item.Expires {
+		return nil, errNotStored
+	}
+	if item.Downloads+item.downloading >= item.MaxDownloads {
+		return nil, errDownloaded
+	}
+	fetched := item.StorePayload
+	return &fetched, nil
+}
+
+// startFetch remembers the receiver on stream fetched the transfer of the channel
+func (st *store) startFetch(stream transmit.GrpcStream, channel string) {
+	st.Lock()
+	defer st.Unlock()
+	st.fetches[stream] = &pendingFetch{channel: channel, at: time.Now()}
+}
+
+// takeFetch returns the channel the receiver on stream fetched
+func (st *store) takeFetch(stream transmit.GrpcStream) (string, bool) {
+	st.Lock()
+	defer st.Unlock()
+	f, ok := st.fetches[stream]
+	if !ok {
+		return "", false
+	}
+	delete(st.fetches, stream)
+	return f.channel, true
+}
+
+var (
+	errNotStored  = errors.New("no transfer stored with the share code")
+	errDownloaded = errors.New("the stored transfer was downloaded already")
+)
+
+// download sends the records of the fetched transfer to stream, the transfer is
+// deleted after its last download. The download ends the session.
+func (st *store) download(stream transmit.GrpcStream, channel string, session *storeSession) {
+	defer st.release(session)
+	st.Lock()
+	item, ok := st.items[channel]
+	if ok && item.Downloads+item.downloading >= item.MaxDownloads {
+		ok = false
+	} else if ok {
+		item.downloading++
+	}
+	st.Unlock()
+	if !ok {
+		_ = stream.Send(message.NewMessage(proto.MessageType_StoreFailed, []byte(errDownloaded.Error())))
+		return
+	}
+	err := st.sendRecords(stream, channel, session.limit)
+	if err == nil {
+		err = stream.Send(message.NewMessage(proto.MessageType_StoreFinish, nil))
+	} else if err == pipe.ErrQuotaExceeded {
+		metrics.Rejected.WithLabelValues(metrics.RejectedQuota).Inc()
+		_ = stream.Send(message.NewMessage(proto.MessageType_ChannelQuotaExceeded, nil))
+	}
+	st.Lock()
+	defer st.Unlock()
+	item.downloading--
+	if current, ok := st.items[channel]; !ok || current != item {
+		// expired while downloading
+		return
+	}
+	if err != nil {
+		log.Printf("download of stored transfer %s failed: %s\n", channel, err)
+		return
+	}
+	item.Downloads++
+	if item.Downloads >= item.MaxDownloads {
+		st.deleteLocked(channel)
+		st.updateMetrics()
+		log.Printf("stored transfer %s downloaded %d times, deleted\n", channel, item.Downloads)
+		return
+	}
+	_ = st.save(item)
+}
+
+// sendRecords sends the records of the stored transfer at the rate of limit
+func (st *store) sendRecords(stream transmit.GrpcStream, channel string, limit *pipe.Limit) error {
+	file, err := os.Open(st.path(channel, ".data"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		size, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if size > maxRecordSize {
+			return fmt.Errorf("record of %d bytes is too large", size)
+		}
+		data := make([]byte, size)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return err
+		}
+		wait, err := limit.Take(len(data))
+		if err != nil {
+			return err
+		}
+		time.Sleep(wait)
+		if err = stream.Send(message.NewMessage(proto.MessageType_StoreData, data)); err != nil {
+			return err
+		}
+	}
+}
+
+// maxRecordSize bounds a record read back, a sender sends chunks far smaller
+const maxRecordSize = 16 * 1024 * 1024
+
+// handleStore uploads and downloads stored transfers, creating one needs a token like creating
+// a channel does and fetching one like joining does.
+func (r *Relay) handleStore(stream transmit.GrpcStream, msg *proto.Message) {
+	if r.store == nil {
+		switch msg.MessageType {
+		case proto.MessageType_Store:
+			_ = stream.Send(message.NewMessage(proto.MessageType_StoreFailed, []byte("the relay doesn't store transfers")))
+		case proto.MessageType_Fetch:
+			_ = stream.Send(message.NewMessage(proto.MessageType_ChannelNotFound, nil))
+		}
+		return
+	}
+	switch msg.MessageType {
+	case proto.MessageType_Store:
+		if r.tokens != nil {
+			if _, ok := r.authorize(stream); !ok {
+				log.Println("store refused: invalid token")
+				_ = stream.Send(message.NewMessage(proto.MessageType_Unauthorized, nil))
+				return
+			}
+		}
+		pm, err := message.ParseMessagePayload(msg)
+		if err == nil && pm == nil {
+			err = errors.New("empty store request")
+		}
+		if err != nil {
+			_ = stream.Send(message.NewMessage(proto.MessageType_StoreFailed, []byte(err.Error())))
+			return
+		}
+		request := pm.(*message.StorePayload)
+		session, ok := r.openStoreSession(stream, request.Channel)
+		if !ok {
+			return
+		}
+		err = r.store.begin(stream, request, session)
+		if err != nil {
+			r.closeStoreSession(session)
+			_ = stream.Send(message.NewMessage(proto.MessageType_StoreFailed, []byte(err.Error())))
+			return
+		}
+		_ = stream.Send(message.NewMessage(proto.MessageType_StoreReady, nil))
+	case proto.MessageType_StoreData:
+		wait, err := r.store.write(stream, msg.Payload)
+		if err == pipe.ErrQuotaExceeded {
+			log.Println("store stopped: channel quota exceeded")
+			metrics.Rejected.WithLabelValues(metrics.RejectedQuota).Inc()
+			_ = stream.Send(message.NewMessage(proto.MessageType_ChannelQuotaExceeded, nil))
+		} else if err != nil {
+			_ = stream.Send(message.NewMessage(proto.MessageType_StoreFailed, []byte(err.Error())))
+		}
+		// slows down the sender, messages of a stream are handled in order
+		time.Sleep(wait)
+	case proto.MessageType_StoreFinish:
+		stored, err := r.store.finish(stream)
+		if err != nil {
+			_ = stream.Send(message.NewMessage(proto.MessageType_StoreFailed, []byte(err.Error())))
+			return
+		}
+		log.Printf("stored transfer %s of %d bytes\n", stored.Channel, stored.Size)
+		payload, _ := (&message.StorePayload{
+			Channel:      stored.Channel,
+			Expires:      stored.Expires,
+			MaxDownloads: stored.MaxDownloads,
+			Size:         stored.Size,
+		}).Bytes(message.JSONProtocol)
+		_ = stream.Send(message.NewMessage(proto.MessageType_StoreSuccess, payload))
+	case proto.MessageType_Fetch:
+		if r.tokens != nil && r.options.AuthJoin {
+			if _, ok := r.authorize(stream); !ok {
+				log.Println("fetch refused: invalid token")
+				_ = stream.Send(message.NewMessage(proto.MessageType_Unauthorized, nil))
+				return
+			}
+		}
+		if !r.allowJoin(stream) {
+			return
+		}
+		pm, err := message.ParseMessagePayload(msg)
+		if err != nil {
+			return
+		}
+		channel := pm.(*message.ShareCodePayload).ShareCode
+		fetched, err := r.store.fetch(channel)
+		if err == errNotStored {
+			_ = stream.Send(message.NewMessage(proto.MessageType_ChannelNotFound, nil))
+			return
+		} else if err != nil {
+			_ = stream.Send(message.NewMessage(proto.MessageType_StoreFailed, []byte(err.Error())))
+			return
+		}
+		r.store.startFetch(stream, channel)
+		payload, _ := fetched.Bytes(message.JSONProtocol)
+		_ = stream.Send(message.NewMessage(proto.MessageType_FetchSuccess, payload))
+	case proto.MessageType_AgreeReceive:
+		// the receiver accepted what it fetched
+		channel, ok := r.store.takeFetch(stream)
+		if !ok {
+			return
+		}
+		session, ok := r.openStoreSession(stream, channel)
+		if ok {
+			go r.store.download(stream, channel, session)
+		}
+	}
+}
+
+// openStoreSession counts an upload or download of the store against the channel limits,
+// false if the relay or the client has no room for it.
+func (r *Relay) openStoreSession(stream transmit.GrpcStream, channel string) (*storeSession, bool) {
+	ip := clientIP(stream)
+	r.Lock()
+	defer r.Unlock()
+	if !r.allowChannel(stream, ip) {
+		return nil, false
+	}
+	r.storeSessions++
+	r.channelsByIP[ip]++
+	return &storeSession{ip: ip, limit: r.channelLimit(channel)}, true
+}
+
+func (r *Relay) closeStoreSession(session *storeSession) {
+	r.Lock()
+	defer r.Unlock()
+	r.storeSessions--
+	if r.channelsByIP[session.ip]--; r.channelsByIP[session.ip] <= 0 {
+		delete(r.channelsByIP, session.ip)
+	}
+}
@@ -0,0 +1,180 @@
+package relay
+
+import (
+	"bytes"
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/proto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testChannel = "0123456789abcdef0123456789abcdef"
+
+// newTestStore is a store in a temporary folder that counts the sessions it ended
+func newTestStore(t *testing.T, dir string, quota int64) (*store, *int) {
+	st, err := newStore(dir, quota, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := 0
+	st.release = func(*storeSession) {
+		released++
+	}
+	return st, &released
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestStoreQuota(t *testing.T) {
+	dir := t.TempDir()
+	st, released := newTestStore(t, dir, 100)
+	stream := &recordingStream{}
+	if err := st.begin(stream, &message.StorePayload{Channel: testChannel}, &storeSession{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.write(stream, make([]byte, 50)); err != nil {
+		t.Fatalf("write within the quota = %v", err)
+	}
+	if _, err := st.write(stream, make([]byte, 50)); err == nil {
+		t.Fatal("write past the quota was stored")
+	}
+	if st.used != 0 || len(st.uploads) != 0 || *released != 1 {
+		t.Errorf("refused upload left %d bytes used, %d uploads, %d sessions ended", st.used, len(st.uploads), *released)
+	}
+	if exists(st.path(testChannel, ".part")) {
+		t.Error("refused upload left its .part file")
+	}
+	if _, err := st.finish(stream); err == nil {
+		t.Error("refused upload could be finished")
+	}
+}
+
+func TestStoreExpire(t *testing.T) {
+	dir := t.TempDir()
+	st, _ := newTestStore(t, dir, 1000)
+	stream := &recordingStream{}
+	// asks for longer than the store keeps transfers
+	if err := st.begin(stream, &message.StorePayload{Channel: testChannel, MaxAge: 48 * 3600}, &storeSession{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.write(stream, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := st.finish(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.MaxAge != 3600 || stored.MaxDownloads != 1 {
+		t.Errorf("stored for %ds and %d downloads, want 3600s and 1", stored.MaxAge, stored.MaxDownloads)
+	}
+	if !exists(st.path(testChannel, ".data")) || !exists(st.path(testChannel, ".json")) || exists(st.path(testChannel, ".part")) {
+		t.Fatal("finished upload isn't in .data and .json files")
+	}
+	if _, err = st.fetch(testChannel); err != nil {
+		t.Fatalf("fetch of a stored transfer = %v", err)
+	}
+
+	// a restarted relay still has it
+	st, _ = newTestStore(t, dir, 1000)
+	if _, err = st.fetch(testChannel); err != nil {
+		t.Fatalf("fetch after a restart = %v", err)
+	}
+
+	st.items[testChannel].Expires = time.Now().Unix() - 1
+	st.expire()
+	if _, err = st.fetch(testChannel); err != errNotStored {
+		t.Errorf("fetch of an expired transfer = %v, want %v", err, errNotStored)
+	}
+	if st.used != 0 || exists(st.path(testChannel, ".data")) || exists(st.path(testChannel, ".json")) {
+		t.Error("expired transfer left its files or bytes used")
+	}
+}
+
+func TestStoreDiscardsPartialUploads(t *testing.T) {
+	dir := t.TempDir()
+	st, released := newTestStore(t, dir, 1000)
+	stream := &recordingStream{}
+	if err := st.begin(stream, &message.StorePayload{Channel: testChannel}, &storeSession{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.write(stream, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the sender stopped sending
+	st.uploads[stream].lastActive = time.Now().Add(-storeIdleTimeout - time.Second)
+	st.expire()
+	if len(st.uploads) != 0 || st.used != 0 || *released != 1 || exists(st.path(testChannel, ".part")) {
+		t.Error("idle upload wasn't discarded")
+	}
+
+	// the relay stopped while uploading
+	if err := st.begin(stream, &message.StorePayload{Channel: testChannel}, &storeSession{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.write(stream, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	_ = st.uploads[stream].writer.Flush()
+	// a .data file without its .json is an interrupted finish
+	if err := os.WriteFile(filepath.Join(dir, "fedcba9876543210fedcba9876543210.data"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	st, _ = newTestStore(t, dir, 1000)
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 || len(st.items) != 0 || st.used != 0 {
+		t.Errorf("restarted store kept %d files and %d transfers of aborted uploads", len(entries), len(st.items))
+	}
+	if _, err := st.fetch(testChannel); err != errNotStored {
+		t.Errorf("fetch of an aborted upload = %v, want %v", err, errNotStored)
+	}
+}
+
+func TestStoreDownloads(t *testing.T) {
+	st, released := newTestStore(t, t.TempDir(), 1000)
+	upload := &recordingStream{}
+	if err := st.begin(upload, &message.StorePayload{Channel: testChannel, MaxDownloads: 2}, &storeSession{}); err != nil {
+		t.Fatal(err)
+	}
+	records := [][]byte{[]byte("first"), []byte("second")}
+	for _, record := range records {
+		if _, err := st.write(upload, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := st.finish(upload); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if _, err := st.fetch(testChannel); err != nil {
+			t.Fatalf("fetch %d = %v", i, err)
+		}
+		download := &recordingStream{}
+		st.download(download, testChannel, &storeSession{})
+		if len(download.sent) != len(records)+1 || download.last() != proto.MessageType_StoreFinish {
+			t.Fatalf("download %d sent %d messages, want the records and StoreFinish", i, len(download.sent))
+		}
+		for j, record := range records {
+			if !bytes.Equal(download.sent[j].Payload, record) {
+				t.Errorf("download %d record %d = %q, want %q", i, j, download.sent[j].Payload, record)
+			}
+		}
+	}
+	if *released != 3 {
+		t.Errorf("%d sessions ended, want the upload and both downloads", *released)
+	}
+	if _, err := st.fetch(testChannel); err != errNotStored {
+		t.Errorf("fetch after the last download = %v, want %v", err, errNotStored)
+	}
+	download := &recordingStream{}
+	st.download(download, testChannel, &storeSession{})
+	if download.last() != proto.MessageType_StoreFailed {
+		t.Errorf("download after the last one sent %v, want StoreFailed", download.last())
+	}
+}
@@ -0,0 +1,100 @@
+package sender
+
+import (
+	"errors"
+	"github.com/duyunis/pdh/files"
+	"github.com/duyunis/pdh/tools"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileHashes hashes the files in order in the background while they are sent, at most one file
+// ahead of the receivers, each file is hashed once for all of them.
+type fileHashes struct {
+	sync.Mutex
+	cond      *sync.Cond
+	algorithm string
+	files     []*files.FileInfo
+	results   []*fileHash
+	// wanted is how many files the receivers need hashed so far
+	wanted  int
+	stopped bool
+}
+
+type fileHash struct {
+	done    chan struct{}
+	hash    []byte
+	imoHash []byte
+	err     error
+}
+
+var errHashStopped = errors.New("hashing stopped")
+
+// hashFiles starts hashing the files, the first one right away
+func hashFiles(fs []*files.FileInfo, algorithm string) *fileHashes {
+	h := &fileHashes{algorithm: algorithm, files: fs, wanted: 1}
+	h.cond = sync.NewCond(&h.Mutex)
+	for range fs {
+		h.results = append(h.results, &fileHash{done: make(chan struct{})})
+	}
+	go h.run()
+	return h
+}
+
+func (h *fileHashes) run() {
+	for index, fileInfo := range h.files {
+		h.Lock()
+		for h.wanted <= index && !h.stopped {
+			h.cond.Wait()
+		}
+		stopped := h.stopped
+		h.Unlock()
+		result := h.results[index]
+		if stopped {
+			result.err = errHashStopped
+		} else if !fileInfo.Stream && os.FileMode(fileInfo.Mode)&os.ModeSymlink == 0 {
+			fullPath := filepath.Clean(fileInfo.FolderSource + string(os.PathSeparator) + fileInfo.Name)
+			// verified by the other after receiving, also identifies the source when resuming
+			result.hash, result.err = tools.HashFile(fullPath, h.algorithm)
+			if result.err == nil {
+				// quick fingerprint, lets the other skip files it already has
+				result.imoHash, result.err = tools.IMOHashFile(fullPath)
+			}
+		}
+		close(result.done)
+	}
+}
+
+// wait sets the hashes of the file at index on fileInfo once they are ready, and starts hashing the next file
+func (h *fileHashes) wait(index int, fileInfo *files.FileInfo, done <-chan struct{}) error {
+	h.Lock()
+	if h.wanted < index+2 {
+		h.wanted = index + 2
+		h.cond.Broadcast()
+	}
+	h.Unlock()
+	result := h.results[index]
+	select {
+	case <-result.done:
+	case <-done:
+		return errHashStopped
+	}
+	if result.err != nil {
+		return result.err
+	}
+	if result.hash != nil {
+		fileInfo.Hash = result.hash
+		fileInfo.HashAlgorithm = h.algorithm
+		fileInfo.IMOHash = result.imoHash
+	}
+	return nil
+}
+
+// stop ends hashing, the files not hashed yet fail
+func (h *fileHashes) stop() {
+	h.Lock()
+	h.stopped = true
+	h.cond.Broadcast()
+	h.Unlock()
+}
@@ -1,10 +1,15 @@
 package sender
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/duyunis/discovery"
 	"github.com/duyunis/pdh/common"
 	"github.com/duyunis/pdh/compress"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/event"
 	"github.com/duyunis/pdh/files"
 	"github.com/duyunis/pdh/message"
 	"github.com/duyunis/pdh/options"
@@ -13,87 +18,179 @@ import (
 	"github.com/duyunis/pdh/transmit"
 	"github.com/duyunis/pdh/transmit/client"
 	"github.com/duyunis/pdh/transmit/server"
-	"github.com/duyunis/progress_bar"
-	"io"
 	"os"
-	"os/signal"
-	"path"
 	"path/filepath"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 )
 
 type Sender struct {
+	common.RWMutex
 	TotalFilesSize            int64
 	longestFilename           int
 	TotalNumberOfContents     int
 	FilesToTransferCurrentNum int
 
-	fs            *files.Files
-	gs            *server.GrpcServer
-	gc            *client.GrpcClient
-	opt           *options.SenderOptions
-	fileHandleMsg chan *proto.Message
-	quit          chan bool
-	done          chan bool
+	fs        *files.Files
+	filter    *files.Filter
+	hashes    *fileHashes
+	gs        *server.GrpcServer
+	gc        *client.GrpcClient
+	broadcast *discovery.Broadcast
+	opt       *options.SenderOptions
+	logger    tools.Logger
+	events    *event.Emitter
+	// codec is the configured compression, nil for none, each receiver may lower it
+	codec     compress.Codec
+	codecName string
+	// relayOpt connects the relay clients
+	relayOpt *options.GrpcClientOptions
+	// certFingerprint is the fingerprint of the certificate of the local network server
+	certFingerprint []byte
+	// dataClients are the relay clients of the extra data streams
+	dataClients []*client.GrpcClient
+	// receivers is how many receivers get the files, sessions are those that connected
+	receivers  int
+	sessions   []*session
+	finishOnce sync.Once
+	err        error
+	done       chan struct{}
 }
 
-// Send files
-func (s *Sender) Send(filePaths []string) {
-	fs, err := files.GetFilesInfo(filePaths, s.opt.Zip)
-	if err != nil {
-		tools.Println(tools.Red, fmt.Sprintf("get files info error: %s", err))
-		os.Exit(1)
+// Send sends the files, or the text of the options, it returns once each receiver
+// received everything or failed, or ctx is done.
+func (s *Sender) Send(ctx context.Context, filePaths []string) (*common.Result, error) {
+	var err error
+	if s.opt.Text != "" {
+		// the text goes with the file stat, no file to send
+		s.fs = &files.Files{}
+	} else {
+		archive := s.opt.Archive
+		if s.opt.Zip {
+			archive = files.ArchiveZip
+		}
+		s.filter = &files.Filter{Exclude: s.opt.Exclude, Include: s.opt.Include, Ignore: s.opt.RespectGitignore}
+		s.fs, err = files.GetFilesInfo(filePaths, archive, s.filter, func(zipPath string) {
+			s.logger.Info(fmt.Sprintf("Zipping %s", zipPath))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("get files info error: %w", err)
+		}
+		if s.receivers > 1 && s.hasStream() && archive == "" {
+			return nil, errors.New("stdin can only be sent to one receiver")
+		}
+		if s.opt.Store && s.hasStream() {
+			return nil, errors.New("stdin can't be stored on the relay")
+		}
 	}
-	s.fs = fs
+	if s.opt.Store {
+		return s.sendToStore(ctx, filePaths)
+	}
+	defer s.cleanup()
 
 	s.TotalNumberOfContents = len(s.fs.FilesInfo)
+	err = s.sendCollectFiles()
+	if err != nil {
+		return nil, fmt.Errorf("collect files error: %w", err)
+	}
+	s.hashes = hashFiles(s.fs.FilesInfo, s.opt.HashAlgorithm)
 
-	if s.opt.LocalNetwork {
-		err = s.sendWithLocalNetwork()
-	} else {
-		err = s.sendWithLocalNetwork()
+	err = s.sendWithLocalNetwork()
+	if err == nil && !s.opt.LocalNetwork {
 		err = s.sendWithRelay()
 	}
 	if err != nil {
-		tools.Println(tools.Red, fmt.Sprintf("send files error: %s", err))
-		os.Exit(1)
+		return nil, fmt.Errorf("send files error: %w", err)
+	}
+	if s.opt.LocalNetwork {
+		s.showShareCode()
+	}
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.finishSessions(ctx.Err())
+		s.finish(ctx.Err())
 	}
-	go s.signal()
-	<-s.done
+	return s.collectResult(), s.err
 }
 
 func (s *Sender) sendWithLocalNetwork() error {
 	opt := &discovery.Options{
 		Duration:       -1,
 		BroadcastDelay: time.Second,
-		Payload:        []byte(s.opt.ShareCode),
+		Payload:        []byte(crypt.ChannelName(s.opt.ShareCode)),
 	}
 
-	broadcast := discovery.NewBroadcast(opt)
-	broadcast.StartAsSync()
+	cert, err := crypt.NewEphemeralCert()
+	if err != nil {
+		return err
+	}
+	s.certFingerprint = crypt.CertFingerprint(cert.Certificate[0])
+
+	s.broadcast = discovery.NewBroadcast(opt)
+	s.broadcast.StartAsSync()
 
-	grpcServer := server.NewPdhGrpcServer(&options.GrpcServerOptions{Address: "0.0.0.0", Ports: s.opt.LocalPort})
-	grpcServer.AddHandler(s)
-	go grpcServer.Start()
+	s.gs = server.NewPdhGrpcServer(&options.GrpcServerOptions{
+		Address: "0.0.0.0",
+		Ports:   s.opt.LocalPort,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	})
+	s.gs.AddHandler(s)
+	go s.gs.Start()
 	return nil
 }
 
 func (s *Sender) sendWithRelay() error {
-	gc := client.NewPdhGrpcClient(s.opt.Relay)
+	gc := client.NewPdhGrpcClientWithOptions(s.opt.Relay, s.relayOpt)
 	gc.AddHandler(s)
 	err := gc.Start()
 	if err != nil {
 		return err
 	}
 	s.gc = gc
-	err = s.gc.Send(message.NewMessage(proto.MessageType_CreateChannel, []byte(s.opt.ShareCode)))
-	return err
+	err = s.gc.Send(s.createChannelMessage(crypt.ChannelName(s.opt.ShareCode)))
+	if err != nil {
+		return err
+	}
+	s.createDataChannels()
+	return nil
 }
 
-func (s *Sender) Done() {
-	if s.opt.Zip {
+// createChannelMessage creates the relay channel name, allowing all receivers to join it
+func (s *Sender) createChannelMessage(name string) *proto.Message {
+	msg := message.NewMessage(proto.MessageType_CreateChannel, []byte(name))
+	if s.receivers > 1 {
+		msg.Receivers = int64(s.receivers)
+	}
+	return msg
+}
+
+// finish ends the transfer, the first call decides the error Send returns
+func (s *Sender) finish(err error) {
+	s.finishOnce.Do(func() {
+		s.err = err
+		s.RLock()
+		// a single receiver reported the end of its transfer already
+		reported := s.receivers == 1 && len(s.sessions) > 0
+		s.RUnlock()
+		if !reported {
+			if err != nil {
+				s.events.Emit(event.Event{Type: event.TransferFailed, Err: err})
+			} else {
+				s.events.Emit(event.Event{Type: event.Completed})
+			}
+		}
+		close(s.done)
+	})
+}
+
+// cleanup closes the connections and removes the zip files once the transfer ended
+func (s *Sender) cleanup() {
+	if s.opt.Zip && s.fs != nil {
 		// delete zip file
 		for _, info := range s.fs.FilesInfo {
 			if strings.HasSuffix(info.Name, ".zip") {
@@ -101,174 +198,218 @@ func (s *Sender) Done() {
 			}
 		}
 	}
+	if s.hashes != nil {
+		s.hashes.stop()
+	}
 	// sleep, send an end message to the other.
 	time.Sleep(time.Second)
-	s.done <- true
+	s.Lock()
+	defer s.Unlock()
+	if s.gc != nil {
+		s.gc.Stop()
+		s.gc = nil
+	}
+	for _, gc := range s.dataClients {
+		gc.Stop()
+	}
+	s.dataClients = nil
+	if s.gs != nil {
+		s.gs.Stop()
+	}
+	if s.broadcast != nil {
+		// blocks until the next broadcast
+		go s.broadcast.StopBroadcast()
+	}
 }
 
-func (s *Sender) signal() {
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
-	for {
-		select {
-		case <-interrupt:
-			if s.gc != nil {
-				_ = s.gc.Send(message.NewMessage(proto.MessageType_Interrupt, nil))
-			}
-			s.Done()
+// showShareCode tells the user how the other receives
+func (s *Sender) showShareCode() {
+	s.events.Emit(event.Event{Type: event.ShareCodeReady, ShareCode: s.opt.ShareCode})
+	s.logger.Info(fmt.Sprintf("share code is: %s", s.opt.ShareCode))
+	s.logger.Info("on the other computer run")
+	s.logger.Info("")
+	if s.opt.LocalNetwork {
+		if s.opt.LocalPort != common.DefaultLocalPort {
+			s.logger.Info(fmt.Sprintf("pdh receive --local --local-port %s %s", s.opt.LocalPort, s.opt.ShareCode))
+		} else {
+			s.logger.Info(fmt.Sprintf("pdh receive --local %s", s.opt.ShareCode))
 		}
+	} else if s.opt.Relay == common.PublicRelay {
+		s.logger.Info(fmt.Sprintf("pdh receive %s", s.opt.ShareCode))
+	} else {
+		s.logger.Info(fmt.Sprintf("pdh receive --relay %s %s", s.opt.Relay, s.opt.ShareCode))
+	}
+	if s.receivers > 1 {
+		s.logger.Info("")
+		s.logger.Info(fmt.Sprintf("on up to %d computers", s.receivers))
 	}
 }
 
 func (s *Sender) HandleMessage(stream transmit.GrpcStream, msg *proto.Message) {
-	var err error
 	switch msg.MessageType {
 	case proto.MessageType_LocalNetworkMode:
+		if s.receivers > 1 {
+			// the other receivers may still come through the relay
+			return
+		}
 		// local network mode, stop relay client
-		s.gc.Stop()
-		s.gc = nil
-	case proto.MessageType_Interrupt:
-		fmt.Println("send interrupt...")
-		s.Done()
-	case proto.MessageType_Cancel:
-		fmt.Println("send cancel")
-		s.Done()
+		s.Lock()
+		if s.gc != nil {
+			s.gc.Stop()
+			s.gc = nil
+		}
+		s.Unlock()
+		s.stopDataChannels()
 	case proto.MessageType_CreateChannelSuccess:
-		fmt.Println("channel created")
-		err := s.sendCollectFiles()
+		s.logger.Info("channel created")
+		s.showShareCode()
+	case proto.MessageType_CreateChannelFailed:
+		s.finish(errors.New("create channel failed"))
+	case proto.MessageType_Unauthorized:
+		s.finish(errors.New("the relay refused the token, set it with --relay-token"))
+	case proto.MessageType_TooManyChannels:
+		s.finish(errors.New("the relay refused the channel, this computer has too many open"))
+	case proto.MessageType_RelayFull:
+		s.finish(errors.New("the relay is full, try again later"))
+	case proto.MessageType_ChannelQuotaExceeded:
+		err := errors.New("the transfer is larger than the relay allows")
+		s.finishSessions(err)
+		s.finish(err)
+	case proto.MessageType_DataStream:
+		err := s.registerDataStream(stream, msg)
 		if err != nil {
-			fmt.Println("collect files error: ", err)
-			os.Exit(1)
+			s.logger.Warn(fmt.Sprintf("register data stream error: %s", err))
 		}
-		fmt.Println("share code is:", s.opt.ShareCode)
-		fmt.Println("on the other computer run")
-		fmt.Println()
-		if s.opt.LocalNetwork {
-			fmt.Println("pdh receive --local", s.opt.ShareCode)
-		} else if s.opt.Relay == common.PublicRelay {
-			if s.opt.LocalNetwork && s.opt.LocalPort != common.DefaultLocalPort {
-				fmt.Println("pdh receive --local --local-port", s.opt.LocalPort, s.opt.ShareCode)
-			} else {
-				fmt.Println("pdh receive", s.opt.ShareCode)
+	case proto.MessageType_Interrupt, proto.MessageType_Cancel:
+		ss := s.session(stream, msg.Peer, false)
+		if ss != nil {
+			ss.HandleMessage(msg)
+			return
+		}
+		if msg.Peer == 0 {
+			// the relay closed the channel of all receivers
+			err := errors.New("canceled by the other")
+			if msg.MessageType == proto.MessageType_Interrupt {
+				err = errors.New("interrupted by the other")
 			}
-		} else {
-			fmt.Println("pdh receive --relay", s.opt.Relay, s.opt.ShareCode)
+			s.finishSessions(err)
+			s.finish(err)
 		}
-	case proto.MessageType_CreateChannelFailed:
-		tools.Println(tools.Red, "create channel failed.")
-		s.Done()
-	case proto.MessageType_GetFileStat:
-		fileStat := &message.FileStatPayload{
-			FilesSize:    s.TotalFilesSize,
-			FilesNumber:  int64(len(s.fs.FilesInfo)),
-			FolderNumber: int64(s.fs.TotalNumberFolders),
+	case proto.MessageType_KeyExchange, proto.MessageType_GetFileStat:
+		ss := s.session(stream, msg.Peer, true)
+		if ss == nil {
+			full := message.NewMessage(proto.MessageType_ChannelFull, nil)
+			full.Peer = msg.Peer
+			_ = stream.Send(full)
+			return
 		}
-		payload, _ := fileStat.Bytes(message.JSONProtocol)
-		err = stream.Send(message.NewMessage(proto.MessageType_FileStat, payload))
-		if err != nil {
-			tools.Println(tools.Red, "stream is error.")
-			s.Done()
+		ss.HandleMessage(msg)
+	default:
+		if ss := s.session(stream, msg.Peer, false); ss != nil {
+			ss.HandleMessage(msg)
 		}
-	case proto.MessageType_RefuseReceive:
-		tools.Println(tools.Yellow, "the other refused receive.")
-		s.Done()
-	case proto.MessageType_SkipFile, proto.MessageType_ReadyForReceive, proto.MessageType_FileFinish:
-		s.fileHandleMsg <- msg
-	case proto.MessageType_AgreeReceive:
-		// start send files
-		fmt.Println()
-		fmt.Println("Sending...")
-		fmt.Println()
-	LOOP:
-		for index, fileInfo := range s.fs.FilesInfo {
-			fileInfoPayload := &message.FileInfoPayload{
-				FileInfo: fileInfo,
-			}
-			payload, _ := fileInfoPayload.Bytes(message.JSONProtocol)
-			err = stream.Send(message.NewMessage(proto.MessageType_FileInfo, payload))
-			if err != nil {
-				tools.Println(tools.Red, "stream is error.")
-				s.Done()
-				return
-			}
+	}
+}
 
-		HANDLE:
-			for {
-				select {
-				case m := <-s.fileHandleMsg:
-					switch m.MessageType {
-					case proto.MessageType_SkipFile:
-						if index == len(s.fs.FilesInfo)-1 {
-							// no file to send
-							_ = stream.Send(message.NewMessage(proto.MessageType_FileFinish, nil))
-						}
-						continue LOOP
-					case proto.MessageType_ReadyForReceive:
-						break HANDLE
-					}
-				}
-			}
+// session returns the session of the receiver, a new one if create is set and
+// not all receivers connected yet, nil otherwise.
+func (s *Sender) session(conn transmit.GrpcStream, peer int64, create bool) *session {
+	s.Lock()
+	defer s.Unlock()
+	for _, ss := range s.sessions {
+		if ss.conn == conn && ss.peer == peer {
+			return ss
+		}
+	}
+	if !create || len(s.sessions) >= s.receivers {
+		return nil
+	}
+	ss := newSession(s, len(s.sessions)+1, conn, peer)
+	s.sessions = append(s.sessions, ss)
+	if s.receivers > 1 {
+		s.logger.Info(fmt.Sprintf("receiver %d of %d connected", ss.number, s.receivers))
+	}
+	return ss
+}
 
-			barOpt := &progress_bar.Options{
-				Describe:     fileInfo.Name,
-				Graph:        ">",
-				IsBytes:      true,
-				ShowPercent:  true,
-				ShowDuration: true,
-			}
-			bar := progress_bar.NewBarWithOptions(fileInfo.Size, barOpt)
-			filePath := path.Join(fileInfo.FolderSource, fileInfo.Name)
-			reading, err := os.Open(filePath)
-			if err != nil {
-				return
+// sessionFinished ends the transfer once all receivers finished
+func (s *Sender) sessionFinished() {
+	s.RLock()
+	finished := 0
+	var errs []error
+	for _, ss := range s.sessions {
+		select {
+		case <-ss.done:
+			finished++
+			if ss.err != nil {
+				errs = append(errs, ss.err)
 			}
-			readingPosition := int64(0)
+		default:
+		}
+	}
+	s.RUnlock()
+	if finished < s.receivers {
+		return
+	}
+	if s.receivers == 1 && len(errs) == 1 {
+		s.finish(errs[0])
+	} else if len(errs) > 0 {
+		s.finish(fmt.Errorf("%d of %d receivers failed", len(errs), s.receivers))
+	} else {
+		s.finish(nil)
+	}
+}
 
-			finish := false
-			EOF := false
+// finishSessions ends the transfers to all receivers, the other is told if interrupted
+func (s *Sender) finishSessions(err error) {
+	s.RLock()
+	sessions := append([]*session{}, s.sessions...)
+	s.RUnlock()
+	for _, ss := range sessions {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			_ = ss.stream.Send(message.NewMessage(proto.MessageType_Interrupt, nil))
+		}
+		ss.finish(err)
+	}
+}
 
-			for {
-				data := make([]byte, common.MaxBufferSize/2)
-				n, err := reading.ReadAt(data, readingPosition)
-				if err != nil {
-					if err == io.EOF {
-						finish = true
-						EOF = true
-					} else {
-						tools.Println(tools.Red, fmt.Sprintf("read file error: %s", err))
-						s.Done()
-						return
-					}
-				}
-				dataToSend := compress.Compress(data[:n])
-				readingPosition += int64(n)
-				pl := &message.FileDataPayload{
-					Data:     dataToSend,
-					Position: readingPosition,
-					EOF:      EOF,
-				}
-				filePayload, _ := pl.Bytes(message.JSONProtocol)
-				fdm := message.NewMessage(proto.MessageType_FileData, filePayload)
-				err = stream.Send(fdm)
-				if err != nil {
-					tools.Println(tools.Red, "stream is error.")
-					s.Done()
-					return
-				}
-				bar.Add(readingPosition)
-				if finish {
-					bar.Finish()
-					break
-				}
-			}
+// collectResult is the result of the single receiver, or of each of several receivers
+func (s *Sender) collectResult() *common.Result {
+	s.RLock()
+	defer s.RUnlock()
+	result := common.Result{ShareCode: s.opt.ShareCode}
+	for _, ss := range s.sessions {
+		ss.RLock()
+		r := ss.result
+		if ss.err != nil {
+			r.Error = ss.err.Error()
+		}
+		ss.RUnlock()
+		if s.receivers == 1 {
+			r.ShareCode, r.Error = result.ShareCode, ""
+			return &r
 		}
-		fmt.Println("Send Completed!")
-		s.Done()
+		result.Bytes += r.Bytes
+		result.Receivers = append(result.Receivers, r)
 	}
+	return &result
+}
+
+func (s *Sender) hasStream() bool {
+	for _, fileInfo := range s.fs.FilesInfo {
+		if fileInfo.Stream {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *Sender) sendCollectFiles() (err error) {
-	for i, fileInfo := range s.fs.FilesInfo {
+	if s.opt.Text != "" {
+		s.logger.Info(fmt.Sprintf("Sending text (%s)", tools.ByteCountDecimal(int64(len(s.opt.Text)))))
+		return
+	}
+	for _, fileInfo := range s.fs.FilesInfo {
 		var fullPath string
 		fullPath = fileInfo.FolderSource + string(os.PathSeparator) + fileInfo.Name
 		fullPath = filepath.Clean(fullPath)
@@ -277,49 +418,125 @@ func (s *Sender) sendCollectFiles() (err error) {
 			s.longestFilename = len(fileInfo.Name)
 		}
 
-		if os.FileMode(fileInfo.Mode)&os.ModeSymlink != 0 {
+		if !fileInfo.Stream && os.FileMode(fileInfo.Mode)&os.ModeSymlink != 0 {
 			fileInfo.Symlink, err = os.Readlink(fullPath)
 			if err != nil {
+				return
 			}
 		}
+		// files are hashed while they are sent
 		s.TotalFilesSize += fileInfo.Size
-		if err != nil {
-			return
-		}
-		fmt.Printf("\r                                 ")
-		fmt.Printf("\rSending %d files (%s)", i, tools.ByteCountDecimal(s.TotalFilesSize))
+	}
+	s.TotalFilesSize += s.fs.ArchivedSize
+	if s.fs.FilteredFiles+s.fs.FilteredFolders > 0 {
+		s.logger.Info(fmt.Sprintf("%d files and %d folders filtered out", s.fs.FilteredFiles, s.fs.FilteredFolders))
 	}
 	fileName := fmt.Sprintf("%d files", len(s.fs.FilesInfo))
 	folderName := fmt.Sprintf("%d folders", s.fs.TotalNumberFolders)
 	if len(s.fs.FilesInfo) == 1 {
 		fileName = fmt.Sprintf("'%s'", s.fs.FilesInfo[0].Name)
+		if s.fs.FilesInfo[0].Archive != "" && s.fs.FilesInfo[0].Stream {
+			s.logger.Info(fmt.Sprintf("Sending %s archive of %s", fileName, tools.ByteCountDecimal(s.TotalFilesSize)))
+			return
+		} else if s.fs.FilesInfo[0].Stream {
+			s.logger.Info(fmt.Sprintf("Sending %s stream", fileName))
+			return
+		}
 	}
 
-	fmt.Printf("\r                                 ")
 	if s.fs.TotalNumberFolders > 0 {
-		fmt.Printf("\rSending %s and %s (%s)\n", fileName, folderName, tools.ByteCountDecimal(s.TotalFilesSize))
+		s.logger.Info(fmt.Sprintf("Sending %s and %s (%s)", fileName, folderName, tools.ByteCountDecimal(s.TotalFilesSize)))
 	} else {
-		fmt.Printf("\rSending %s (%s)\n", fileName, tools.ByteCountDecimal(s.TotalFilesSize))
+		s.logger.Info(fmt.Sprintf("Sending %s (%s)", fileName, tools.ByteCountDecimal(s.TotalFilesSize)))
 	}
 	return
 }
 
-func checkOptions(opt *options.SenderOptions) {
+// checkOptions validates opt, Streams defaults to one and HashAlgorithm to xxhash when unset
+func checkOptions(opt *options.SenderOptions) error {
 	if tools.IsBlank(opt.ShareCode) {
-		tools.Println(tools.Red, "share code can't empty")
-		os.Exit(1)
+		return errors.New("share code can't empty")
 	}
-	if opt.LocalNetwork && opt.Relay != common.PublicRelay {
-		tools.Println(tools.Yellow, "you enable local network, relay will disabled")
+	if err := crypt.ValidateCode(opt.ShareCode); err != nil {
+		return fmt.Errorf("invalid share code: %w", err)
+	}
+	if len(opt.Text) > common.MaxTextSize {
+		return fmt.Errorf("text can't be larger than %s", tools.ByteCountDecimal(common.MaxTextSize))
 	}
+	if opt.Streams == 0 {
+		opt.Streams = 1
+	}
+	if opt.HashAlgorithm == "" {
+		opt.HashAlgorithm = "xxhash"
+	}
+	if opt.Streams < 1 || opt.Streams > common.MaxStreams {
+		return fmt.Errorf("streams must be between 1 and %d", common.MaxStreams)
+	}
+	if opt.Receivers < 0 || opt.Receivers > common.MaxReceivers {
+		return fmt.Errorf("receivers must be between 1 and %d", common.MaxReceivers)
+	}
+	switch opt.Archive {
+	case "", files.ArchiveTar, files.ArchiveTarZst:
+	default:
+		return fmt.Errorf("unsupported archive: %s", opt.Archive)
+	}
+	if opt.Archive != "" && opt.Zip {
+		return errors.New("--zip can't be combined with --archive")
+	}
+	if opt.Store {
+		if opt.LocalNetwork || opt.Zip || opt.Archive != "" || opt.Receivers > 1 {
+			return errors.New("--store can't be combined with --local, --zip, --archive or --receivers")
+		}
+		if crypt.SecretBits(opt.ShareCode) < crypt.StoreSecretBits {
+			return errors.New("the share code is too short to store the transfer, use a longer --code-length")
+		}
+		if opt.Expire < 0 || opt.MaxDownloads < 0 {
+			return errors.New("--expire and --max-downloads can't be negative")
+		}
+	}
+	switch opt.HashAlgorithm {
+	case "imohash", "md5", "xxhash":
+	default:
+		return fmt.Errorf("unsupported hash algorithm: %s", opt.HashAlgorithm)
+	}
+	return nil
 }
 
-func NewSender(opt *options.SenderOptions) *Sender {
-	checkOptions(opt)
-	return &Sender{
-		opt:           opt,
-		fileHandleMsg: make(chan *proto.Message, 10),
-		quit:          make(chan bool, 1),
-		done:          make(chan bool, 1),
+// NewSender checks the options, logger and observer may be nil
+func NewSender(opt *options.SenderOptions, logger tools.Logger, observer event.Observer) (*Sender, error) {
+	if err := checkOptions(opt); err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = tools.NopLogger
+	}
+	if opt.LocalNetwork && opt.Relay != common.PublicRelay {
+		logger.Warn("you enable local network, relay will disabled")
 	}
+	relayTLS, err := client.RelayTLSConfig(opt.RelayCA, opt.RelayTLS)
+	if err != nil {
+		return nil, fmt.Errorf("relay tls error: %w", err)
+	}
+	codecName := opt.Compression
+	if codecName == "" {
+		codecName = compress.Zstd
+	}
+	codec, err := compress.Get(codecName, opt.CompressionLevel)
+	if err != nil {
+		return nil, fmt.Errorf("compression error: %w", err)
+	}
+	receivers := opt.Receivers
+	if receivers == 0 {
+		receivers = 1
+	}
+	return &Sender{
+		opt:       opt,
+		logger:    logger,
+		events:    event.NewEmitter(observer),
+		relayOpt:  &options.GrpcClientOptions{TLSConfig: relayTLS, Token: opt.RelayToken},
+		codec:     codec,
+		codecName: codecName,
+		receivers: receivers,
+		done:      make(chan struct{}),
+	}, nil
 }
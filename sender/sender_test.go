@@ -0,0 +1,27 @@
+package sender
+
+import (
+	"github.com/duyunis/pdh/options"
+	"testing"
+)
+
+func TestCheckOptionsStoreCode(t *testing.T) {
+	cases := []struct {
+		code string
+		ok   bool
+	}{
+		{"1a2b-3c4d-5e6f-7a8b", false},
+		{"1a2b-3c4d-5e6f-7a8b-9c0d-1e2f-3a4b", false},
+		{"1a2b-3c4d-5e6f-7a8b-9c0d-1e2f-3a4b-5c6d", true},
+		{"7-apple-river-zebra-apple-river-zebra-apple", false},
+		{"7-apple-river-zebra-apple-river-zebra-apple-river-zebra-apple-river-zebra-apple-river-zebra", true},
+	}
+	for _, c := range cases {
+		err := checkOptions(&options.SenderOptions{ShareCode: c.code, Store: true})
+		if c.ok && err != nil {
+			t.Errorf("checkOptions(%q) = %v, want no error", c.code, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("checkOptions(%q) = nil, want an error", c.code)
+		}
+	}
+}
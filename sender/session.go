@@ -0,0 +1,419 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"github.com/duyunis/pdh/common"
+	"github.com/duyunis/pdh/compress"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/event"
+	"github.com/duyunis/pdh/files"
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/proto"
+	"github.com/duyunis/pdh/tools"
+	"github.com/duyunis/pdh/transmit"
+	"github.com/schollz/pake/v3"
+	"os"
+	"path"
+	"sync"
+)
+
+// session is the transfer to one receiver, each receiver exchanges its own key,
+// accepts, skips and resumes files on its own.
+type session struct {
+	common.RWMutex
+	s *Sender
+	// number of the receiver, from 1 in the order they connected
+	number int
+	// conn and peer identify the receiver, peer is set by a relay channel of several receivers
+	conn   transmit.GrpcStream
+	peer   int64
+	stream transmit.GrpcStream
+	logger tools.Logger
+	key    []byte
+	// codec and codecName are negotiated with the receiver
+	codec           compress.Codec
+	codecName       string
+	dataStreams     map[int64]transmit.GrpcStream
+	dataStreamAdded chan bool
+	fileHandleMsg   chan *proto.Message
+	result          common.Result
+	finishOnce      sync.Once
+	err             error
+	done            chan struct{}
+}
+
+// peerStream sends to a receiver of a relay channel of several, the relay routes by peer
+type peerStream struct {
+	stream transmit.GrpcStream
+	peer   int64
+}
+
+func (p *peerStream) Send(msg *proto.Message) error {
+	msg.Peer = p.peer
+	return p.stream.Send(msg)
+}
+
+// receiverLogger prefixes the messages of a session with the number of its receiver
+type receiverLogger struct {
+	logger tools.Logger
+	prefix string
+}
+
+func (l *receiverLogger) Info(msg string) {
+	if msg != "" {
+		msg = l.prefix + msg
+	}
+	l.logger.Info(msg)
+}
+
+func (l *receiverLogger) Warn(msg string) {
+	l.logger.Warn(l.prefix + msg)
+}
+
+func (l *receiverLogger) Error(msg string) {
+	l.logger.Error(l.prefix + msg)
+}
+
+func newSession(s *Sender, number int, conn transmit.GrpcStream, peer int64) *session {
+	ss := &session{
+		s:               s,
+		number:          number,
+		conn:            conn,
+		peer:            peer,
+		stream:          conn,
+		logger:          s.logger,
+		codec:           s.codec,
+		codecName:       s.codecName,
+		dataStreams:     make(map[int64]transmit.GrpcStream),
+		dataStreamAdded: make(chan bool, 1),
+		fileHandleMsg:   make(chan *proto.Message, 10),
+		done:            make(chan struct{}),
+	}
+	if peer != 0 {
+		ss.stream = &peerStream{stream: conn, peer: peer}
+	}
+	if s.receivers > 1 {
+		ss.logger = &receiverLogger{logger: s.logger, prefix: fmt.Sprintf("receiver %d: ", number)}
+	}
+	return ss
+}
+
+// emit passes an event of the session, numbered by the receiver when sending to several
+func (ss *session) emit(e event.Event) {
+	if ss.s.receivers > 1 {
+		e.Receiver = ss.number
+	}
+	ss.s.events.Emit(e)
+}
+
+// finish ends the transfer to the receiver, the first call decides its error
+func (ss *session) finish(err error) {
+	ss.finishOnce.Do(func() {
+		ss.Lock()
+		ss.err = err
+		ss.Unlock()
+		if err != nil {
+			if ss.s.receivers > 1 {
+				ss.logger.Warn(fmt.Sprintf("failed: %s", err))
+			}
+			ss.emit(event.Event{Type: event.TransferFailed, Err: err})
+		} else {
+			ss.emit(event.Event{Type: event.Completed})
+		}
+		close(ss.done)
+		ss.s.sessionFinished()
+	})
+}
+
+func (ss *session) HandleMessage(msg *proto.Message) {
+	var err error
+	switch msg.MessageType {
+	case proto.MessageType_Interrupt:
+		ss.finish(errors.New("interrupted by the other"))
+	case proto.MessageType_Cancel:
+		ss.finish(errors.New("canceled by the other"))
+	case proto.MessageType_KeyExchange:
+		err = ss.exchangeKey(msg)
+		if err != nil {
+			ss.finish(fmt.Errorf("key exchange failed: %w", err))
+		}
+	case proto.MessageType_GetFileStat:
+		key := ss.sessionKey()
+		if key == nil {
+			// never send anything in plaintext
+			_ = ss.stream.Send(message.NewMessage(proto.MessageType_Failed, []byte("key exchange required")))
+			ss.finish(errors.New("the other did not exchange key, please upgrade pdh on the other computer"))
+			return
+		}
+		pm, err := message.ParseEncryptedMessagePayload(msg, key)
+		if err != nil {
+			ss.finish(fmt.Errorf("get file stat request error: %w", err))
+			return
+		}
+		request, ok := pm.(*message.GetFileStatPayload)
+		if !ok || request == nil {
+			ss.finish(errors.New("get file stat request error: invalid request"))
+			return
+		}
+		ss.negotiateCodec(request.Codecs)
+		s := ss.s
+		fileStat := &message.FileStatPayload{
+			FilesSize:       s.TotalFilesSize,
+			FilesNumber:     int64(len(s.fs.FilesInfo)),
+			FolderNumber:    int64(s.fs.TotalNumberFolders),
+			Streams:         int64(s.opt.Streams),
+			Stream:          s.hasStream(),
+			Text:            s.opt.Text,
+			CertFingerprint: s.certFingerprint,
+		}
+		for _, folder := range s.fs.EmptyFolders {
+			fileStat.EmptyFolders = append(fileStat.EmptyFolders, folder.FolderRemote)
+		}
+		payload, _ := fileStat.Bytes(message.JSONProtocol)
+		fsm, err := message.NewEncryptedMessage(proto.MessageType_FileStat, payload, key)
+		if err != nil {
+			ss.finish(fmt.Errorf("encrypt file stat error: %w", err))
+			return
+		}
+		err = ss.stream.Send(fsm)
+		if err != nil {
+			ss.finish(fmt.Errorf("stream is error: %w", err))
+		}
+	case proto.MessageType_RefuseReceive:
+		ss.finish(errors.New("the other refused receive"))
+	case proto.MessageType_SkipFile, proto.MessageType_ReadyForReceive, proto.MessageType_ResumeReceive, proto.MessageType_FileFinish:
+		select {
+		case ss.fileHandleMsg <- msg:
+		case <-ss.done:
+		}
+	case proto.MessageType_AgreeReceive:
+		pm, err := message.ParseEncryptedMessagePayload(msg, ss.sessionKey())
+		if err != nil {
+			ss.finish(fmt.Errorf("agree receive error: %w", err))
+			return
+		}
+		agree, ok := pm.(*message.AgreeReceivePayload)
+		if !ok || agree == nil {
+			ss.finish(errors.New("agree receive error: invalid payload"))
+			return
+		}
+		s := ss.s
+		if s.opt.Text != "" {
+			ss.Lock()
+			ss.result.Text = s.opt.Text
+			ss.Unlock()
+			ss.logger.Info("Send Completed!")
+			ss.finish(nil)
+			return
+		}
+		started := event.Event{
+			Type:  event.TransferStarted,
+			Files: int64(len(s.fs.FilesInfo)),
+			Size:  s.TotalFilesSize,
+		}
+		for _, fileInfo := range s.fs.FilesInfo {
+			started.Names = append(started.Names, path.Join(fileInfo.FolderRemote, fileInfo.Name))
+		}
+		ss.emit(started)
+		streams := int(agree.Streams)
+		if streams < 1 || streams > s.opt.Streams {
+			streams = 1
+		}
+		// start send files, replies from the other are handled while sending
+		go ss.sendFiles(streams)
+	}
+}
+
+func (ss *session) sessionKey() []byte {
+	ss.RLock()
+	defer ss.RUnlock()
+	return ss.key
+}
+
+// sendFiles sends the files one by one, each file starts from the position the other asked for
+func (ss *session) sendFiles(streamCount int) {
+	s := ss.s
+	streams := ss.waitDataStreams(streamCount)
+	ss.logger.Info("")
+	ss.logger.Info("Sending...")
+	ss.logger.Info("")
+LOOP:
+	for index, shared := range s.fs.FilesInfo {
+		// the other receivers get their own copy
+		fileInfo := *shared
+		fileInfo.IsEncrypted = true
+		err := s.hashes.wait(index, &fileInfo, ss.done)
+		if err != nil {
+			ss.finish(fmt.Errorf("hash file [%s] error: %w", fileInfo.Name, err))
+			return
+		}
+		var chunks *compress.Chunks
+		fileInfo.Codec = ss.codecName
+		fileInfo.IsCompressed = ss.codec != nil && compress.Compressible(fileInfo.Name)
+		if fileInfo.IsCompressed {
+			chunks = compress.NewChunks(ss.codec)
+		}
+		fileInfoPayload := &message.FileInfoPayload{
+			FileInfo: &fileInfo,
+		}
+		payload, _ := fileInfoPayload.Bytes(message.JSONProtocol)
+		fim, err := message.NewEncryptedMessage(proto.MessageType_FileInfo, payload, ss.key)
+		if err != nil {
+			ss.finish(fmt.Errorf("encrypt file info error: %w", err))
+			return
+		}
+		err = ss.stream.Send(fim)
+		if err != nil {
+			ss.finish(fmt.Errorf("stream is error: %w", err))
+			return
+		}
+
+		filePath := path.Join(fileInfo.FolderSource, fileInfo.Name)
+		readingPosition := int64(0)
+	HANDLE:
+		for {
+			select {
+			case <-ss.done:
+				return
+			case m := <-ss.fileHandleMsg:
+				switch m.MessageType {
+				case proto.MessageType_SkipFile:
+					ss.Lock()
+					ss.result.Skipped = append(ss.result.Skipped, filePath)
+					ss.Unlock()
+					ss.emit(event.Event{Type: event.FileSkipped, File: fileInfo.Name, Size: fileInfo.Size})
+					if index == len(s.fs.FilesInfo)-1 {
+						// no file to send
+						_ = ss.stream.Send(message.NewMessage(proto.MessageType_FileFinish, nil))
+						break LOOP
+					}
+					continue LOOP
+				case proto.MessageType_ReadyForReceive:
+					break HANDLE
+				case proto.MessageType_ResumeReceive:
+					pm, err := message.ParseEncryptedMessagePayload(m, ss.key)
+					if err != nil {
+						ss.finish(fmt.Errorf("get resume position error: %w", err))
+						return
+					}
+					resume, ok := pm.(*message.ResumePayload)
+					if !ok || resume == nil {
+						ss.finish(fmt.Errorf("get resume position error: invalid payload of [%s]", fileInfo.Name))
+						return
+					}
+					if resume.Position < 0 || resume.Position > fileInfo.Size {
+						ss.finish(fmt.Errorf("invalid resume position %d of [%s]", resume.Position, fileInfo.Name))
+						return
+					}
+					readingPosition = resume.Position
+					ss.logger.Warn(fmt.Sprintf("resume [%s] from %s", fileInfo.Name, tools.ByteCountDecimal(readingPosition)))
+					break HANDLE
+				}
+			}
+		}
+
+		size := fileInfo.Size
+		if fileInfo.Stream {
+			size = -1
+		}
+		ss.emit(event.Event{Type: event.FileStarted, File: fileInfo.Name, Size: size, Bytes: readingPosition})
+		if fileInfo.Stream && fileInfo.Archive != "" {
+			// the folder is archived while it's sent
+			archive := files.ArchiveReader([]string{fileInfo.FolderSource}, fileInfo.Archive, s.filter)
+			size, err = ss.sendStream(ss.stream, fileInfo.Name, archive, chunks)
+			_ = archive.Close()
+			if err != nil {
+				ss.finish(fmt.Errorf("send archive [%s] error: %w", fileInfo.Name, err))
+				return
+			}
+			ss.addSent(fileInfo.FolderSource)
+			ss.emit(event.Event{Type: event.FileCompleted, File: fileInfo.Name, Size: size})
+			continue
+		} else if fileInfo.Stream {
+			size, err = ss.sendStream(ss.stream, fileInfo.Name, os.Stdin, chunks)
+			if err != nil {
+				ss.finish(fmt.Errorf("send stream [%s] error: %w", fileInfo.Name, err))
+				return
+			}
+			ss.addSent(fileInfo.Name)
+			ss.emit(event.Event{Type: event.FileCompleted, File: fileInfo.Name, Size: size})
+			continue
+		}
+
+		reading, err := os.Open(filePath)
+		if err != nil {
+			ss.finish(fmt.Errorf("open file error: %w", err))
+			return
+		}
+
+		err = ss.sendFileData(streams, &fileInfo, reading, readingPosition, chunks)
+		_ = reading.Close()
+		if err != nil {
+			ss.finish(fmt.Errorf("send file [%s] error: %w", fileInfo.Name, err))
+			return
+		}
+		ss.addSent(filePath)
+		ss.emit(event.Event{Type: event.FileCompleted, File: fileInfo.Name, Size: fileInfo.Size})
+	}
+	ss.logger.Info("Send Completed!")
+	ss.finish(nil)
+}
+
+// addSent counts a file the other got all data of
+func (ss *session) addSent(filePath string) {
+	ss.Lock()
+	ss.result.Files = append(ss.result.Files, filePath)
+	ss.Unlock()
+}
+
+// exchangeKey answers the PAKE started by the receiver, both sides derive
+// the same key only if they know the same share code.
+func (ss *session) exchangeKey(msg *proto.Message) error {
+	pm, err := message.ParseMessagePayload(msg)
+	if err != nil {
+		return err
+	}
+	exchange, ok := pm.(*message.KeyExchangePayload)
+	if !ok || exchange == nil {
+		return errors.New("invalid key exchange payload")
+	}
+	p, err := pake.InitCurve([]byte(ss.s.opt.ShareCode), 1, crypt.Curve)
+	if err != nil {
+		return err
+	}
+	if err = p.Update(exchange.Pake); err != nil {
+		return err
+	}
+	sessionKey, err := p.SessionKey()
+	if err != nil {
+		return err
+	}
+	key, salt, err := crypt.New(sessionKey, nil)
+	if err != nil {
+		return err
+	}
+	ss.Lock()
+	ss.key = key
+	ss.Unlock()
+	reply := &message.KeyExchangePayload{
+		Pake: p.Bytes(),
+		Salt: salt,
+	}
+	payload, _ := reply.Bytes(message.JSONProtocol)
+	return ss.stream.Send(message.NewMessage(proto.MessageType_KeyExchange, payload))
+}
+
+// negotiateCodec drops the codec if the other can't decompress it
+func (ss *session) negotiateCodec(codecs []string) {
+	if ss.codec == nil {
+		return
+	}
+	for _, codec := range codecs {
+		if codec == ss.codecName {
+			return
+		}
+	}
+	ss.logger.Warn(fmt.Sprintf("the other doesn't support %s compression, sending uncompressed", ss.codecName))
+	ss.codec, ss.codecName = nil, compress.None
+}
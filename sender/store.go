@@ -0,0 +1,195 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/duyunis/pdh/common"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/event"
+	"github.com/duyunis/pdh/files"
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/proto"
+	"github.com/duyunis/pdh/tools"
+	"github.com/duyunis/pdh/transmit"
+	"github.com/duyunis/pdh/transmit/client"
+	"io"
+	"time"
+)
+
+// storeReadyTimeout is how long to wait for the relay to accept the upload, older relays never answer
+const storeReadyTimeout = time.Second * 10
+
+// storeUpload passes the replies of the relay to the upload
+type storeUpload struct {
+	replies chan *proto.Message
+}
+
+func (u *storeUpload) HandleMessage(stream transmit.GrpcStream, msg *proto.Message) {
+	if msg.MessageType == proto.MessageType_Ping {
+		return
+	}
+	select {
+	case u.replies <- msg:
+	default:
+	}
+}
+
+// sendToStore uploads the files or the text to the relay, encrypted with a key of the share code,
+// it returns once the relay stored everything, the receiver downloads it later without the sender.
+func (s *Sender) sendToStore(ctx context.Context, filePaths []string) (*common.Result, error) {
+	for _, fileInfo := range s.fs.FilesInfo {
+		s.TotalFilesSize += fileInfo.Size
+	}
+	if s.opt.Text != "" {
+		s.logger.Info(fmt.Sprintf("Storing text (%s) on the relay", tools.ByteCountDecimal(int64(len(s.opt.Text)))))
+	} else {
+		s.logger.Info(fmt.Sprintf("Storing %d files and %d folders (%s) on the relay", len(s.fs.FilesInfo), s.fs.TotalNumberFolders, tools.ByteCountDecimal(s.TotalFilesSize)))
+	}
+
+	key, salt, err := crypt.StoreKey(s.opt.ShareCode, nil)
+	if err != nil {
+		return nil, err
+	}
+	stat := &message.FileStatPayload{
+		FilesSize:    s.TotalFilesSize,
+		FilesNumber:  int64(len(s.fs.FilesInfo)),
+		FolderNumber: int64(s.fs.TotalNumberFolders),
+		Text:         s.opt.Text,
+	}
+	statPayload, _ := stat.Bytes(message.JSONProtocol)
+	manifest, err := message.NewEncryptedMessage(proto.MessageType_FileStat, statPayload, key)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &storeUpload{replies: make(chan *proto.Message, 16)}
+	gc := client.NewPdhGrpcClientWithOptions(s.opt.Relay, s.relayOpt)
+	gc.AddHandler(upload)
+	err = gc.Start()
+	if err != nil {
+		return nil, err
+	}
+	defer gc.Stop()
+	wait := func(timeout <-chan time.Time) (*proto.Message, error) {
+		select {
+		case <-timeout:
+			return nil, errors.New("the relay doesn't answer, it may not store transfers")
+		case reply := <-upload.replies:
+			switch reply.MessageType {
+			case proto.MessageType_StoreFailed:
+				return nil, fmt.Errorf("the relay refused to store: %s", reply.Payload)
+			case proto.MessageType_Unauthorized:
+				return nil, errors.New("the relay refused the token, check --relay-token")
+			case proto.MessageType_TooManyChannels:
+				return nil, errors.New("the relay refused to store, this computer has too many transfers open")
+			case proto.MessageType_RelayFull:
+				return nil, errors.New("the relay is full, try again later")
+			}
+			return reply, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	request, _ := (&message.StorePayload{
+		Channel:      crypt.ChannelName(s.opt.ShareCode),
+		Salt:         salt,
+		Manifest:     manifest.Payload,
+		MaxAge:       int64(s.opt.Expire / time.Second),
+		MaxDownloads: int64(s.opt.MaxDownloads),
+	}).Bytes(message.JSONProtocol)
+	err = gc.Send(message.NewMessage(proto.MessageType_Store, request))
+	if err != nil {
+		return nil, err
+	}
+	reply, err := wait(time.After(storeReadyTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if reply.MessageType != proto.MessageType_StoreReady {
+		return nil, fmt.Errorf("unexpected reply of the relay: %s", reply.MessageType)
+	}
+
+	result := &common.Result{ShareCode: s.opt.ShareCode, Text: s.opt.Text}
+	if s.opt.Text == "" {
+		name := "files.tar.zst"
+		if len(filePaths) == 1 {
+			name = files.ArchiveName(filePaths[0], files.ArchiveTarZst)
+		}
+		s.events.Emit(event.Event{Type: event.FileStarted, File: name, Size: -1})
+		reader := files.ArchiveReader(filePaths, files.ArchiveTarZst, s.filter)
+		defer reader.Close()
+		result.Bytes, err = s.uploadData(ctx, gc, upload, key, name, reader)
+		if err != nil {
+			return nil, err
+		}
+		s.events.Emit(event.Event{Type: event.FileCompleted, File: name, Size: result.Bytes})
+		result.Files = filePaths
+	}
+
+	err = gc.Send(message.NewMessage(proto.MessageType_StoreFinish, nil))
+	if err != nil {
+		return nil, err
+	}
+	reply, err = wait(nil)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := message.ParseMessagePayload(reply)
+	if err != nil || pm == nil || reply.MessageType != proto.MessageType_StoreSuccess {
+		return nil, fmt.Errorf("unexpected reply of the relay: %s", reply.MessageType)
+	}
+	stored := pm.(*message.StorePayload)
+	s.logger.Info(fmt.Sprintf("Stored %s on the relay until %s, for %d downloads",
+		tools.ByteCountDecimal(stored.Size), time.Unix(stored.Expires, 0).Format("2006-01-02 15:04:05"), stored.MaxDownloads))
+	s.showShareCode()
+	s.events.Emit(event.Event{Type: event.Completed})
+	return result, nil
+}
+
+// uploadData sends the archive in encrypted chunks like file data, the relay stores them as they
+// are, returns the length of the archive.
+func (s *Sender) uploadData(ctx context.Context, gc *client.GrpcClient, upload *storeUpload, key []byte, name string, reader io.Reader) (int64, error) {
+	data := make([]byte, common.MaxBufferSize/2)
+	position := int64(0)
+	for {
+		select {
+		case reply := <-upload.replies:
+			if reply.MessageType == proto.MessageType_StoreFailed {
+				return position, fmt.Errorf("the relay stopped storing: %s", reply.Payload)
+			}
+			if reply.MessageType == proto.MessageType_ChannelQuotaExceeded {
+				return position, errors.New("the transfer is larger than the relay allows")
+			}
+			return position, fmt.Errorf("unexpected reply of the relay: %s", reply.MessageType)
+		case <-ctx.Done():
+			return position, ctx.Err()
+		default:
+		}
+		n, err := io.ReadFull(reader, data)
+		EOF := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !EOF {
+			return position, err
+		}
+		position += int64(n)
+		pl := &message.FileDataPayload{Position: position, EOF: EOF, Data: data[:n]}
+		filePayload, _ := pl.Bytes(message.RawProtocol)
+		fdm, err := message.NewEncryptedMessage(proto.MessageType_StoreData, filePayload, key)
+		if err != nil {
+			return position, err
+		}
+		err = gc.Send(fdm)
+		if err != nil {
+			return position, err
+		}
+		size := int64(-1)
+		if EOF {
+			size = position
+		}
+		s.events.Emit(event.Event{Type: event.BytesTransferred, File: name, Size: size, Bytes: position})
+		if EOF {
+			return position, nil
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"github.com/duyunis/pdh/common"
+	"github.com/duyunis/pdh/compress"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/event"
+	"github.com/duyunis/pdh/files"
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/proto"
+	"github.com/duyunis/pdh/transmit"
+	"github.com/duyunis/pdh/transmit/client"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// dataStreamTimeout is how long to wait for the other to open its data streams
+const dataStreamTimeout = time.Second * 10
+
+// dataChannel handles an extra relay channel that only carries file data
+type dataChannel struct {
+	s *Sender
+}
+
+func (d *dataChannel) HandleMessage(stream transmit.GrpcStream, msg *proto.Message) {
+	switch msg.MessageType {
+	case proto.MessageType_DataStream, proto.MessageType_ChannelQuotaExceeded:
+		d.s.HandleMessage(stream, msg)
+	case proto.MessageType_CreateChannelFailed, proto.MessageType_Unauthorized, proto.MessageType_TooManyChannels, proto.MessageType_RelayFull:
+		d.s.logger.Warn("create data channel failed, sending with less streams.")
+	}
+}
+
+// createDataChannels creates the relay channels the other joins to open its extra data streams
+func (s *Sender) createDataChannels() {
+	for i := 1; i < s.opt.Streams; i++ {
+		gc := client.NewPdhGrpcClientWithOptions(s.opt.Relay, s.relayOpt)
+		gc.AddHandler(&dataChannel{s: s})
+		err := gc.Start()
+		if err == nil {
+			err = gc.Send(s.createChannelMessage(crypt.DataChannelName(s.opt.ShareCode, i)))
+		}
+		if err != nil {
+			s.logger.Warn(fmt.Sprintf("create data channel error: %s", err))
+			gc.Stop()
+			continue
+		}
+		s.dataClients = append(s.dataClients, gc)
+	}
+}
+
+func (s *Sender) stopDataChannels() {
+	s.Lock()
+	defer s.Unlock()
+	for _, gc := range s.dataClients {
+		gc.Stop()
+	}
+	s.dataClients = nil
+}
+
+// registerDataStream remembers the stream a receiver opened for file data, the
+// receiver is the one whose key the request is encrypted with.
+func (s *Sender) registerDataStream(stream transmit.GrpcStream, msg *proto.Message) error {
+	s.RLock()
+	sessions := append([]*session{}, s.sessions...)
+	s.RUnlock()
+	for _, ss := range sessions {
+		key := ss.sessionKey()
+		if key == nil {
+			continue
+		}
+		pm, err := message.ParseEncryptedMessagePayload(msg, key)
+		if err != nil {
+			continue
+		}
+		ds, ok := pm.(*message.DataStreamPayload)
+		if !ok || ds == nil || ds.Index < 1 || ds.Index >= int64(s.opt.Streams) {
+			return errors.New("invalid data stream")
+		}
+		if msg.Peer != 0 {
+			stream = &peerStream{stream: stream, peer: msg.Peer}
+		}
+		ss.Lock()
+		ss.dataStreams[ds.Index] = stream
+		ss.Unlock()
+		select {
+		case ss.dataStreamAdded <- true:
+		default:
+		}
+		return nil
+	}
+	return errors.New("data stream of an unknown receiver")
+}
+
+// waitDataStreams returns the main stream and the data streams the other opened
+func (ss *session) waitDataStreams(count int) []transmit.GrpcStream {
+	timeout := time.After(dataStreamTimeout)
+	for {
+		ss.RLock()
+		registered := len(ss.dataStreams)
+		ss.RUnlock()
+		if registered >= count-1 {
+			break
+		}
+		select {
+		case <-ss.dataStreamAdded:
+		case <-timeout:
+			ss.logger.Warn(fmt.Sprintf("only %d of %d data streams opened", registered, count-1))
+			count = registered + 1
+		}
+	}
+	ss.RLock()
+	defer ss.RUnlock()
+	streams := []transmit.GrpcStream{ss.stream}
+	for _, stream := range ss.dataStreams {
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// sendFileData sends the file from start, the chunks are spread over the streams in turn
+// and each stream sends its chunks in order.
+func (ss *session) sendFileData(streams []transmit.GrpcStream, fileInfo *files.FileInfo, reading *os.File, start int64, chunks *compress.Chunks) error {
+	if start >= fileInfo.Size {
+		err := ss.sendChunk(streams[0], chunks, nil, fileInfo.Size, true)
+		if err == nil {
+			ss.emit(event.Event{Type: event.BytesTransferred, File: fileInfo.Name, Size: fileInfo.Size, Bytes: fileInfo.Size})
+		}
+		return err
+	}
+	chunkSize := int64(common.MaxBufferSize / 2)
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		sent     = start
+	)
+	for i, stream := range streams {
+		wg.Add(1)
+		go func(offset int64, stream transmit.GrpcStream) {
+			defer wg.Done()
+			data := make([]byte, chunkSize)
+			for ; offset < fileInfo.Size; offset += chunkSize * int64(len(streams)) {
+				size := chunkSize
+				if fileInfo.Size-offset < size {
+					size = fileInfo.Size - offset
+				}
+				n, err := reading.ReadAt(data[:size], offset)
+				if int64(n) < size {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("file changed while sending: %v", err)
+					}
+					lock.Unlock()
+					return
+				}
+				end := offset + int64(n)
+				err = ss.sendChunk(stream, chunks, data[:n], end, end >= fileInfo.Size)
+				lock.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				failed := firstErr != nil
+				sent += int64(n)
+				if err == nil {
+					ss.emit(event.Event{Type: event.BytesTransferred, File: fileInfo.Name, Size: fileInfo.Size, Bytes: sent})
+				}
+				lock.Unlock()
+				ss.addBytes(int64(n))
+				if failed {
+					return
+				}
+			}
+		}(start+int64(i)*chunkSize, stream)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// sendStream sends data of unknown length in order over a single stream, the last chunk is marked EOF,
+// returns the length of the data.
+func (ss *session) sendStream(stream transmit.GrpcStream, name string, reader io.Reader, chunks *compress.Chunks) (int64, error) {
+	data := make([]byte, common.MaxBufferSize/2)
+	position := int64(0)
+	for {
+		n, err := io.ReadFull(reader, data)
+		EOF := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !EOF {
+			return position, err
+		}
+		position += int64(n)
+		err = ss.sendChunk(stream, chunks, data[:n], position, EOF)
+		if err != nil {
+			return position, err
+		}
+		ss.addBytes(int64(n))
+		size := int64(-1)
+		if EOF {
+			size = position
+		}
+		ss.emit(event.Event{Type: event.BytesTransferred, File: name, Size: size, Bytes: position})
+		if EOF {
+			return position, nil
+		}
+	}
+}
+
+// addBytes counts file data sent to the other
+func (ss *session) addBytes(n int64) {
+	ss.Lock()
+	ss.result.Bytes += n
+	ss.Unlock()
+}
+
+// sendChunk sends data that ends at position of the file, compressed by chunks if it pays off
+func (ss *session) sendChunk(stream transmit.GrpcStream, chunks *compress.Chunks, data []byte, position int64, EOF bool) error {
+	pl := &message.FileDataPayload{
+		Position: position,
+		EOF:      EOF,
+	}
+	pl.Data, pl.Compressed = chunks.Compress(data)
+	if pl.Data == nil {
+		// the empty last chunk of a file still has to arrive
+		pl.Data = []byte{}
+	}
+	filePayload, _ := pl.Bytes(message.RawProtocol)
+	fdm, err := message.NewEncryptedMessage(proto.MessageType_FileData, filePayload, ss.key)
+	if err != nil {
+		return err
+	}
+	return stream.Send(fdm)
+}
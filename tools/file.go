@@ -10,7 +10,6 @@ import (
 	"github.com/cespare/xxhash/v2"
 	"github.com/kalafut/imohash"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -30,14 +29,15 @@ func IsEmptyFolder(folderPath string) (bool, error) {
 	return false, nil
 }
 
-func ZipDirectory(destination string, source string) error {
+// ZipDirectory zips source to destination, skip may be nil or leaves out files and folders,
+// added may be nil or is called with each file added to the zip.
+func ZipDirectory(destination string, source string, skip func(path string, info os.FileInfo) bool, added func(zipPath string)) error {
 	if _, err := os.Stat(destination); err == nil {
-		log.Fatalf("%s file already exists!\n", destination)
+		return fmt.Errorf("%s file already exists", destination)
 	}
-	_, _ = fmt.Fprintf(os.Stderr, "Zipping %s to %s\n", source, destination)
 	file, err := os.Create(destination)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 	defer file.Close()
 	writer := zip.NewWriter(file)
@@ -45,77 +45,40 @@ func ZipDirectory(destination string, source string) error {
 	writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
 		return flate.NewWriter(out, flate.NoCompression)
 	})
-	defer writer.Close()
 	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Fatalln(err)
+			return err
+		}
+		if skip != nil && skip(path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 		if info.Mode().IsRegular() {
 			f1, err := os.Open(path)
 			if err != nil {
-				log.Fatalln(err)
+				return err
 			}
 			defer f1.Close()
 			zipPath := strings.ReplaceAll(path, source, strings.TrimSuffix(destination, ".zip"))
 			w1, err := writer.Create(zipPath)
 			if err != nil {
-				log.Fatalln(err)
+				return err
 			}
 			if _, err := io.Copy(w1, f1); err != nil {
-				log.Fatalln(err)
+				return err
+			}
+			if added != nil {
+				added(zipPath)
 			}
-			_, _ = fmt.Fprintf(os.Stderr, "\r\033[2K")
-			_, _ = fmt.Fprintf(os.Stderr, "\rAdding %s", zipPath)
 		}
 		return nil
 	})
-	if err != nil {
-		log.Fatalln(err)
-	}
-	_, _ = fmt.Fprintf(os.Stderr, "\n")
-	return nil
-}
-
-func UnzipDirectory(destination string, source string) error {
-
-	archive, err := zip.OpenReader(source)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	defer archive.Close()
-
-	for _, f := range archive.File {
-		filePath := filepath.Join(destination, f.Name)
-		_, _ = fmt.Fprintf(os.Stderr, "\r\033[2K")
-		_, _ = fmt.Fprintf(os.Stderr, "\rUnzipping file %s", filePath)
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(filePath, os.ModePerm)
-			continue
-		}
-
-		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
-			log.Fatalln(err)
-		}
-
-		dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			log.Fatalln(err)
-		}
-
-		fileInArchive, err := f.Open()
-		if err != nil {
-			log.Fatalln(err)
-		}
-
-		if _, err := io.Copy(dstFile, fileInArchive); err != nil {
-			log.Fatalln(err)
-		}
-
-		dstFile.Close()
-		fileInArchive.Close()
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
 	}
-	_, _ = fmt.Fprintf(os.Stderr, "\n")
-	return nil
+	return err
 }
 
 func GetAbsolutePaths(paths []string) []string {
@@ -0,0 +1,34 @@
+package tools
+
+import "fmt"
+
+// Logger gets the messages of a transfer that are meant for the user
+type Logger interface {
+	Info(msg string)
+	Warn(msg string)
+	Error(msg string)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string)  {}
+func (nopLogger) Warn(string)  {}
+func (nopLogger) Error(string) {}
+
+// NopLogger drops all messages
+var NopLogger Logger = nopLogger{}
+
+// ConsoleLogger prints the messages to the terminal, warnings and errors in color
+type ConsoleLogger struct{}
+
+func (ConsoleLogger) Info(msg string) {
+	fmt.Println("\r" + msg)
+}
+
+func (ConsoleLogger) Warn(msg string) {
+	Println(Yellow, "\r"+msg)
+}
+
+func (ConsoleLogger) Error(msg string) {
+	Println(Red, "\r"+msg)
+}
@@ -2,12 +2,13 @@ package tools
 
 import (
 	"bufio"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
+	"math"
 	"os"
+	"strconv"
 	"strings"
-	"time"
 )
 
 func IsEmpty(s string) bool {
@@ -18,18 +19,21 @@ func IsBlank(s string) bool {
 	return len(strings.Trim(s, " ")) == 0
 }
 
-func GenRandStr(n int, join string) string {
+// GenRandStr generates n random 16 bit hex groups joined by join, it reads from crypto/rand
+// so the result can't be predicted.
+func GenRandStr(n int, join string) (string, error) {
 	result := ""
-	rand.Seed(time.Now().UnixNano())
 	b := make([]byte, 2)
 	for i := 0; i < n; i++ {
-		rand.Read(b)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("read random bytes: %w", err)
+		}
 		result += hex.EncodeToString(b)
 		if i < n-1 {
 			result += join
 		}
 	}
-	return result
+	return result, nil
 }
 
 func ByteCountDecimal(b int64) string {
@@ -45,6 +49,34 @@ func ByteCountDecimal(b int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
 }
 
+// ParseByteCount parses a size like 512, 10k, 1.5GB or 2GiB, units are powers of 1024 like ByteCountDecimal
+func ParseByteCount(s string) (int64, error) {
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	binary := strings.HasSuffix(number, "I")
+	number = strings.TrimRight(strings.TrimSuffix(number, "I"), " ")
+	exp := 0
+	if number != "" {
+		exp = strings.IndexByte("KMGTPE", number[len(number)-1]) + 1
+	}
+	if exp > 0 {
+		number = number[:len(number)-1]
+	} else if binary {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || math.IsNaN(n) || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	for ; exp > 0; exp-- {
+		n *= 1024
+	}
+	// float64(math.MaxInt64) rounds up to 1 << 63
+	if n >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(n), nil
+}
+
 func GetInput(prompt string) string {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Fprintf(os.Stderr, "%s", prompt)
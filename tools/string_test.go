@@ -0,0 +1,69 @@
+package tools
+
+import "testing"
+
+func TestParseByteCount(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"10k", 10 << 10, false},
+		{"10K", 10 << 10, false},
+		{"10KB", 10 << 10, false},
+		{"10kib", 10 << 10, false},
+		{"10 KiB", 10 << 10, false},
+		{" 3m ", 3 << 20, false},
+		{"1.5GB", 3 << 29, false},
+		{"2GiB", 2 << 30, false},
+		{"1T", 1 << 40, false},
+		{"1P", 1 << 50, false},
+		{"7E", 7 << 60, false},
+		{"0.5", 0, false},
+		{"1e3", 1000, false},
+		{"8E", 0, true},
+		{"16EiB", 0, true},
+		{"1e30", 0, true},
+		{"9223372036854775807", 0, true},
+		{"", 0, true},
+		{"KB", 0, true},
+		{"-1", 0, true},
+		{"-1K", 0, true},
+		{"ten", 0, true},
+		{"10X", 0, true},
+		{"10i", 0, true},
+		{"10KK", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+	}
+	for _, c := range cases {
+		got, err := ParseByteCount(c.in)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseByteCount(%q) error = %v, want error %v", c.in, err, c.wantErr)
+			continue
+		}
+		if err == nil && got != c.want {
+			t.Errorf("ParseByteCount(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestByteCountDecimal(t *testing.T) {
+	cases := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 kB"},
+		{3 << 29, "1.5 GB"},
+	}
+	for _, c := range cases {
+		if got := ByteCountDecimal(c.in); got != c.want {
+			t.Errorf("ByteCountDecimal(%d) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package transfer runs pdh transfers inside other programs, errors are returned
+// and nothing is printed or read from stdin unless a Logger or Prompter does it.
+package transfer
+
+import (
+	"context"
+	"github.com/duyunis/pdh/common"
+	"github.com/duyunis/pdh/crypt"
+	"github.com/duyunis/pdh/event"
+	"github.com/duyunis/pdh/options"
+	"github.com/duyunis/pdh/receiver"
+	"github.com/duyunis/pdh/sender"
+	"github.com/duyunis/pdh/tools"
+	"io"
+)
+
+type (
+	// Result is what a transfer did
+	Result = common.Result
+	// Logger gets the messages meant for the user
+	Logger = tools.Logger
+	// Prompter decides whether to accept a transfer and overwrite existing files
+	Prompter = receiver.Prompter
+	// Offer is what the sender wants to send
+	Offer = receiver.Offer
+	// Observer gets the progress of a transfer as events
+	Observer = event.Observer
+)
+
+type SendOptions struct {
+	options.SenderOptions
+	// Logger may be nil
+	Logger Logger
+	// Observer may be nil
+	Observer Observer
+}
+
+type ReceiveOptions struct {
+	options.ReceiverOptions
+	// Logger may be nil
+	Logger Logger
+	// Observer may be nil
+	Observer Observer
+	// Prompter may be nil, then every transfer is accepted and no file overwritten unless Yes or OnConflict say so
+	Prompter Prompter
+}
+
+// Sink is where the received files are written
+type Sink struct {
+	dir    string
+	writer io.Writer
+}
+
+// ToDir writes the received files below dir, a received text is written to the file dir instead,
+// an empty dir is the working directory for files and keeps a text in the result only.
+func ToDir(dir string) Sink {
+	return Sink{dir: dir}
+}
+
+// ToWriter writes the data of a single received file, or the text, to w
+func ToWriter(w io.Writer) Sink {
+	return Sink{writer: w}
+}
+
+// NewCode generates a share code, see crypt.NewCode
+func NewCode(length int, phrase bool) (string, error) {
+	return crypt.NewCode(length, phrase)
+}
+
+// Send sends sources, or the text of opt, to the receiver that uses the same share code.
+// Sources are file or folder paths, or "-" for stdin.
+func Send(ctx context.Context, opt *SendOptions, sources []string) (*Result, error) {
+	s, err := sender.NewSender(&opt.SenderOptions, opt.Logger, opt.Observer)
+	if err != nil {
+		return nil, err
+	}
+	return s.Send(ctx, sources)
+}
+
+// Receive receives what the sender with the same share code sends into sink
+func Receive(ctx context.Context, opt *ReceiveOptions, sink Sink) (*Result, error) {
+	receiverOpt := opt.ReceiverOptions
+	receiverOpt.OutPath = sink.dir
+	r, err := receiver.NewReceiver(&receiverOpt, opt.Logger, opt.Observer, opt.Prompter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Receive(ctx, sink.writer)
+}
@@ -3,12 +3,14 @@ package client
 import (
 	"context"
 	"errors"
-	"fmt"
 	"github.com/duyunis/pdh/common"
+	"github.com/duyunis/pdh/options"
 	"github.com/duyunis/pdh/proto"
 	"github.com/duyunis/pdh/transmit"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 )
 
 type GrpcClient struct {
@@ -16,23 +18,34 @@ type GrpcClient struct {
 	conn     *grpc.ClientConn
 	client   proto.PdhServiceClient
 	stream   proto.PdhService_TransmitClient
+	wrapper  *transmit.ClientStreamWrapper
 	handlers []transmit.MessageHandler
 	target   string
+	options  *options.GrpcClientOptions
 }
 
 func (p *GrpcClient) Start() error {
-	conn, err := grpc.Dial(p.target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds := insecure.NewCredentials()
+	if p.options.TLSConfig != nil {
+		creds = credentials.NewTLS(p.options.TLSConfig)
+	}
+	conn, err := grpc.Dial(p.target, grpc.WithTransportCredentials(creds))
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
 	p.conn = conn
 	p.client = proto.NewPdhServiceClient(conn)
-	stream, err := p.client.Transmit(context.Background())
+	ctx := context.Background()
+	if p.options.Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, common.TokenMetadataKey, p.options.Token)
+	}
+	stream, err := p.client.Transmit(ctx)
 	if err != nil {
 		return err
 	}
 	p.stream = stream
+	// the handlers get the same stream with every message
+	p.wrapper = transmit.NewClientStreamWrapper(stream)
 
 	go p.receive()
 	return nil
@@ -72,7 +85,7 @@ func (p *GrpcClient) Send(msg *proto.Message) error {
 		p.Start()
 	}
 	if p.stream != nil {
-		err := p.stream.Send(msg)
+		err := p.wrapper.Send(msg)
 		return err
 	}
 	return errors.New("stream is nil")
@@ -85,8 +98,8 @@ func (p *GrpcClient) receive() {
 		if err != nil {
 			return
 		}
-		streamWrapper := transmit.NewClientStreamWrapper(p.stream)
-		go p.dispatchMessage(msg, streamWrapper)
+		// dispatch in order, file data must not be reordered
+		p.dispatchMessage(msg, p.wrapper)
 	}
 }
 
@@ -97,8 +110,13 @@ func (p *GrpcClient) dispatchMessage(msg *proto.Message, cw *transmit.ClientStre
 }
 
 func NewPdhGrpcClient(target string) *GrpcClient {
+	return NewPdhGrpcClientWithOptions(target, &options.GrpcClientOptions{})
+}
+
+func NewPdhGrpcClientWithOptions(target string, opt *options.GrpcClientOptions) *GrpcClient {
 	return &GrpcClient{
 		target:   target,
+		options:  opt,
 		handlers: make([]transmit.MessageHandler, 0),
 	}
 }
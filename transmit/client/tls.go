@@ -0,0 +1,63 @@
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/duyunis/pdh/crypt"
+	"os"
+)
+
+// RelayTLSConfig returns the TLS config to connect to a relay, nil if TLS isn't used.
+// The relay is verified with the CA in caFile, or with the system roots.
+func RelayTLSConfig(caFile string, systemRoots bool) (*tls.Config, error) {
+	if caFile == "" {
+		if systemRoots {
+			return &tls.Config{MinVersion: tls.VersionTLS12}, nil
+		}
+		return nil, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if systemRoots {
+		if pool, err = x509.SystemCertPool(); err != nil {
+			return nil, err
+		}
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// PinnedTLSConfig accepts the certificate of the other by its fingerprint. Without a
+// fingerprint any certificate is accepted and seen gets its fingerprint, to be checked
+// once the key is exchanged.
+func PinnedTLSConfig(fingerprint []byte, seen func(fingerprint []byte)) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// the certificate is self-signed, it's verified by its fingerprint below
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no certificate")
+			}
+			fp := crypt.CertFingerprint(rawCerts[0])
+			if fingerprint != nil && !bytes.Equal(fp, fingerprint) {
+				return errors.New("certificate fingerprint mismatch")
+			}
+			if seen != nil {
+				seen(fp)
+			}
+			return nil
+		},
+	}
+}
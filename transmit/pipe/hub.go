@@ -0,0 +1,189 @@
+package pipe
+
+import (
+	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/metrics"
+	"github.com/duyunis/pdh/proto"
+	"github.com/duyunis/pdh/transmit"
+	"log"
+	"sync"
+)
+
+// Hub connects an owner with several visitors, the visitors are numbered from 1 in the
+// order they join. Messages of a visitor reach the owner with its number as Peer, messages
+// of the owner go to the visitor of their Peer, or to all visitors without one.
+type Hub struct {
+	sync.RWMutex
+	owner    *transmit.ServerStreamWrapper
+	visitors map[int64]*hubVisitor
+	joined   int64
+	quit     chan struct{}
+	stopOnce sync.Once
+	// limit bounds the bytes forwarded to and from all visitors, nil for no limit
+	limit *Limit
+}
+
+type hubVisitor struct {
+	stream *transmit.ServerStreamWrapper
+	quit   chan struct{}
+}
+
+// Start forwards the messages of the owner
+func (h *Hub) Start() {
+	h.owner.StartWriteToChannel()
+	go func() {
+		for {
+			select {
+			case <-h.quit:
+				return
+			case m := <-h.owner.Ch:
+				peer := m.Peer
+				m.Peer = 0
+				if peer == 0 {
+					for _, id := range h.Peers() {
+						h.sendToVisitor(id, m)
+					}
+				} else {
+					h.sendToVisitor(peer, m)
+				}
+			}
+		}
+	}()
+}
+
+// Join adds a visitor and forwards its messages, it returns the number of the visitor
+func (h *Hub) Join(visitor *transmit.ServerStreamWrapper) int64 {
+	h.Lock()
+	h.joined++
+	id := h.joined
+	v := &hubVisitor{stream: visitor, quit: make(chan struct{})}
+	h.visitors[id] = v
+	h.Unlock()
+	visitor.StartWriteToChannel()
+	go func() {
+		for {
+			select {
+			case <-h.quit:
+				return
+			case <-v.quit:
+				return
+			case m := <-visitor.Ch:
+				if !h.take(len(m.Payload)) {
+					return
+				}
+				m.Peer = id
+				err := h.owner.Send(m)
+				if err != nil {
+					metrics.PipeErrors.Inc()
+					log.Printf("send message error: %s\n", err)
+					h.Stop()
+					return
+				}
+				metrics.PipeBytes.WithLabelValues(metrics.VisitorToOwner).Add(float64(len(m.Payload)))
+			}
+		}
+	}()
+	return id
+}
+
+// Joined is the number of visitors that joined, including those that left
+func (h *Hub) Joined() int64 {
+	h.RLock()
+	defer h.RUnlock()
+	return h.joined
+}
+
+// Peers are the numbers of the visitors still connected
+func (h *Hub) Peers() []int64 {
+	h.RLock()
+	defer h.RUnlock()
+	peers := make([]int64, 0, len(h.visitors))
+	for id := range h.visitors {
+		peers = append(peers, id)
+	}
+	return peers
+}
+
+// Visitor returns the stream of the visitor, nil if it left
+func (h *Hub) Visitor(id int64) *transmit.ServerStreamWrapper {
+	h.RLock()
+	defer h.RUnlock()
+	if v, ok := h.visitors[id]; ok {
+		return v.stream
+	}
+	return nil
+}
+
+func (h *Hub) sendToVisitor(id int64, m *proto.Message) {
+	visitor := h.Visitor(id)
+	if visitor == nil || !h.take(len(m.Payload)) {
+		return
+	}
+	err := visitor.Send(m)
+	if err != nil {
+		metrics.PipeErrors.Inc()
+		log.Printf("send message error: %s\n", err)
+		h.Leave(id)
+		return
+	}
+	metrics.PipeBytes.WithLabelValues(metrics.OwnerToVisitor).Add(float64(len(m.Payload)))
+}
+
+// take waits for the limit to allow n bytes, false once the hub is over its quota and stopped
+func (h *Hub) take(n int) bool {
+	err := wait(h.limit, n, h.quit)
+	if err == ErrQuotaExceeded {
+		log.Println("channel closed: quota exceeded")
+		metrics.Rejected.WithLabelValues(metrics.RejectedQuota).Inc()
+		_ = h.owner.Send(message.NewMessage(proto.MessageType_ChannelQuotaExceeded, nil))
+		for _, id := range h.Peers() {
+			if visitor := h.Visitor(id); visitor != nil {
+				_ = visitor.Send(message.NewMessage(proto.MessageType_ChannelQuotaExceeded, nil))
+			}
+		}
+		h.Stop()
+	}
+	return err == nil
+}
+
+// Leave removes a visitor that is gone and tells the owner, the others go on
+func (h *Hub) Leave(id int64) {
+	h.Lock()
+	v, ok := h.visitors[id]
+	delete(h.visitors, id)
+	h.Unlock()
+	if !ok {
+		return
+	}
+	close(v.quit)
+	v.stream.StopWriteToChannel()
+	cancel := message.NewMessage(proto.MessageType_Cancel, nil)
+	cancel.Peer = id
+	_ = h.owner.Send(cancel)
+}
+
+// Stop cancels the owner and all visitors
+func (h *Hub) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.quit)
+		h.owner.StopWriteToChannel()
+		_ = h.owner.Send(message.NewMessage(proto.MessageType_Cancel, nil))
+		h.Lock()
+		defer h.Unlock()
+		for id, v := range h.visitors {
+			v.stream.StopWriteToChannel()
+			_ = v.stream.Send(message.NewMessage(proto.MessageType_Cancel, nil))
+			delete(h.visitors, id)
+		}
+	})
+}
+
+// CreateHub lets visitors join owner, limit may be nil
+func CreateHub(owner *transmit.ServerStreamWrapper, limit *Limit) *Hub {
+	return &Hub{
+		owner:    owner,
+		visitors: make(map[int64]*hubVisitor),
+		quit:     make(chan struct{}),
+		limit:    limit,
+	}
+}
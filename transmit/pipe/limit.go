@@ -0,0 +1,64 @@
+package pipe
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned once a channel forwarded more bytes than its limit allows
+var ErrQuotaExceeded = errors.New("channel quota exceeded")
+
+// Limit bounds the bytes a channel forwards in both directions and their rate, a nil Limit allows anything
+type Limit struct {
+	sync.Mutex
+	// MaxBytes of payload the channel forwards, 0 is unlimited
+	MaxBytes int64
+	// Rate is the bytes per second the channel forwards, 0 is unlimited
+	Rate int64
+	used int64
+	// next is when the rate allows the next bytes
+	next time.Time
+}
+
+// Take counts n bytes, it returns how long to wait before forwarding them to keep the rate
+func (l *Limit) Take(n int) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.used += int64(n)
+	if l.MaxBytes > 0 && l.used > l.MaxBytes {
+		return 0, ErrQuotaExceeded
+	}
+	if l.Rate <= 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.Rate))
+	return d, nil
+}
+
+// errStopped is returned by wait when the channel stopped while waiting
+var errStopped = errors.New("channel stopped")
+
+// wait takes n bytes of the limit and waits for the rate to allow them, unless quit is closed first
+func wait(limit *Limit, n int, quit <-chan struct{}) error {
+	d, err := limit.Take(n)
+	if err != nil || d <= 0 {
+		return err
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-quit:
+		return errStopped
+	}
+}
@@ -0,0 +1,65 @@
+package pipe
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimitQuota(t *testing.T) {
+	l := &Limit{MaxBytes: 10}
+	for _, n := range []int{6, 4} {
+		if _, err := l.Take(n); err != nil {
+			t.Fatalf("Take(%d) within the quota = %v", n, err)
+		}
+	}
+	if _, err := l.Take(1); err != ErrQuotaExceeded {
+		t.Errorf("Take past the quota = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	var unlimited *Limit
+	if d, err := unlimited.Take(1 << 30); d != 0 || err != nil {
+		t.Errorf("Take of a nil limit = %v, %v", d, err)
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	l := &Limit{Rate: 1000}
+	cases := []struct {
+		n    int
+		want time.Duration
+	}{
+		{500, 0},
+		{500, 500 * time.Millisecond},
+		{1000, time.Second},
+		{0, 2 * time.Second},
+	}
+	for _, c := range cases {
+		d, err := l.Take(c.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the time since the first Take shortens the wait a little
+		if d > c.want || d < c.want-100*time.Millisecond {
+			t.Errorf("Take(%d) waits %v, want about %v", c.n, d, c.want)
+		}
+	}
+}
+
+func TestWaitStops(t *testing.T) {
+	l := &Limit{Rate: 1}
+	if err := wait(l, 1, nil); err != nil {
+		t.Fatalf("the first byte waited: %v", err)
+	}
+	quit := make(chan struct{})
+	close(quit)
+	start := time.Now()
+	if err := wait(l, 1, quit); err != errStopped {
+		t.Errorf("wait with quit closed = %v, want %v", err, errStopped)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("wait didn't return when quit was closed")
+	}
+	if err := wait(&Limit{MaxBytes: 1}, 2, nil); err != ErrQuotaExceeded {
+		t.Errorf("wait past the quota = %v, want %v", err, ErrQuotaExceeded)
+	}
+}
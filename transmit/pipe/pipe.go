@@ -2,9 +2,11 @@ package pipe
 
 import (
 	"github.com/duyunis/pdh/message"
+	"github.com/duyunis/pdh/metrics"
 	"github.com/duyunis/pdh/proto"
 	"github.com/duyunis/pdh/transmit"
 	"log"
+	"sync"
 	"sync/atomic"
 )
 
@@ -13,6 +15,10 @@ type Pipe struct {
 	second  *transmit.ServerStreamWrapper
 	quit    chan bool
 	running atomic.Bool
+	// limit bounds the bytes forwarded, nil for no limit
+	limit    *Limit
+	stopped  chan struct{}
+	stopOnce sync.Once
 }
 
 func (p *Pipe) chanFromStream(stream proto.PdhService_TransmitServer) chan *proto.Message {
@@ -36,39 +42,57 @@ func (p *Pipe) chanFromStream(stream proto.PdhService_TransmitServer) chan *prot
 func (p *Pipe) Start() {
 
 	p.running.Store(true)
+	p.first.StartWriteToChannel()
+	p.second.StartWriteToChannel()
 	go func() {
-		p.first.StartWriteToChannel()
-		p.second.StartWriteToChannel()
 	LOOP:
 		for {
 			select {
 			case <-p.quit:
 				break LOOP
 			case m1 := <-p.first.Ch:
-				err := p.second.Send(m1)
-				if err != nil {
-					_ = p.first.Send(message.NewMessage(proto.MessageType_Failed, []byte("stream is closed")))
-					p.Stop()
-					log.Printf("send message error: %s\n", err)
-				}
+				p.forward(m1, p.first, p.second, metrics.OwnerToVisitor)
 			case m2 := <-p.second.Ch:
-				err := p.first.Send(m2)
-				if err != nil {
-					_ = p.second.Send(message.NewMessage(proto.MessageType_Failed, []byte("stream is closed")))
-					p.Stop()
-					log.Printf("send message error: %s\n", err)
-				}
+				p.forward(m2, p.second, p.first, metrics.VisitorToOwner)
 			}
 		}
 	}()
 }
 
+// forward sends a message to the other side once the limit allows it, the pipe stops
+// when the channel is over its quota or the other side is gone.
+func (p *Pipe) forward(m *proto.Message, from, to *transmit.ServerStreamWrapper, direction string) {
+	err := wait(p.limit, len(m.Payload), p.stopped)
+	if err == ErrQuotaExceeded {
+		log.Println("channel closed: quota exceeded")
+		metrics.Rejected.WithLabelValues(metrics.RejectedQuota).Inc()
+		_ = from.Send(message.NewMessage(proto.MessageType_ChannelQuotaExceeded, nil))
+		_ = to.Send(message.NewMessage(proto.MessageType_ChannelQuotaExceeded, nil))
+		p.Stop()
+		return
+	} else if err != nil {
+		return
+	}
+	err = to.Send(m)
+	if err == nil {
+		metrics.PipeBytes.WithLabelValues(direction).Add(float64(len(m.Payload)))
+	} else {
+		metrics.PipeErrors.Inc()
+		_ = from.Send(message.NewMessage(proto.MessageType_Failed, []byte("stream is closed")))
+		p.Stop()
+		log.Printf("send message error: %s\n", err)
+	}
+}
+
 func (p *Pipe) Stop() {
-	p.notifyBoth()
-	p.quit <- true
-	p.running.Store(false)
-	p.first.StopWriteToChannel()
-	p.second.StopWriteToChannel()
+	p.stopOnce.Do(func() {
+		close(p.stopped)
+		p.notifyBoth()
+		p.quit <- true
+		p.running.Store(false)
+		p.first.StopWriteToChannel()
+		p.second.StopWriteToChannel()
+	})
 }
 
 func (p *Pipe) notifyBoth() {
@@ -76,10 +100,13 @@ func (p *Pipe) notifyBoth() {
 	_ = p.second.Send(message.NewMessage(proto.MessageType_Cancel, nil))
 }
 
-func CreatePipe(first, second *transmit.ServerStreamWrapper) *Pipe {
+// CreatePipe connects first and second, limit may be nil
+func CreatePipe(first, second *transmit.ServerStreamWrapper, limit *Limit) *Pipe {
 	return &Pipe{
-		first:  first,
-		second: second,
-		quit:   make(chan bool, 1),
+		first:   first,
+		second:  second,
+		quit:    make(chan bool, 1),
+		limit:   limit,
+		stopped: make(chan struct{}),
 	}
 }
@@ -3,11 +3,13 @@ package server
 import (
 	"errors"
 	"github.com/duyunis/pdh/common"
+	"github.com/duyunis/pdh/metrics"
 	"github.com/duyunis/pdh/options"
 	"github.com/duyunis/pdh/proto"
 	"github.com/duyunis/pdh/tools"
 	"github.com/duyunis/pdh/transmit"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"net"
 )
 
@@ -21,11 +23,16 @@ type GrpcServer struct {
 }
 
 func (p *GrpcServer) Transmit(stream proto.PdhService_TransmitServer) error {
+	genKey, err := tools.GenRandStr(4, ".")
+	if err != nil {
+		return err
+	}
 	p.Lock()
-	genKey := tools.GenRandStr(4, ".")
 	sw := transmit.NewServerStreamWrapper(stream)
 	p.streams[genKey] = sw
 	p.Unlock()
+	metrics.GrpcStreams.Inc()
+	defer metrics.GrpcStreams.Dec()
 	for {
 		msg, err := stream.Recv()
 		if err != nil {
@@ -34,7 +41,8 @@ func (p *GrpcServer) Transmit(stream proto.PdhService_TransmitServer) error {
 			p.Unlock()
 			return err
 		}
-		go p.dispatchMessage(msg, sw)
+		// dispatch in order, file data must not be reordered
+		p.dispatchMessage(msg, sw)
 	}
 }
 
@@ -98,8 +106,12 @@ func (p *GrpcServer) Stop() {
 }
 
 func NewPdhGrpcServer(opt *options.GrpcServerOptions) *GrpcServer {
+	var serverOpts []grpc.ServerOption
+	if opt.TLSConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(opt.TLSConfig)))
+	}
 	return &GrpcServer{
-		server:   grpc.NewServer(),
+		server:   grpc.NewServer(serverOpts...),
 		options:  opt,
 		handlers: make([]transmit.MessageHandler, 0),
 		streams:  make(map[string]*transmit.ServerStreamWrapper, 0),
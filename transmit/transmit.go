@@ -2,6 +2,7 @@ package transmit
 
 import (
 	"github.com/duyunis/pdh/proto"
+	"sync"
 	"sync/atomic"
 )
 
@@ -14,6 +15,8 @@ type GrpcStream interface {
 }
 
 type ServerStreamWrapper struct {
+	// sendLock serializes the sends of several goroutines, a stream allows one at a time
+	sendLock  sync.Mutex
 	Stream    proto.PdhService_TransmitServer
 	handlers  []MessageHandler
 	Ch        chan *proto.Message
@@ -21,6 +24,8 @@ type ServerStreamWrapper struct {
 }
 
 func (s *ServerStreamWrapper) Send(msg *proto.Message) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
 	return s.Stream.Send(msg)
 }
 
@@ -40,10 +45,13 @@ func NewServerStreamWrapper(stream proto.PdhService_TransmitServer) *ServerStrea
 }
 
 type ClientStreamWrapper struct {
-	Stream proto.PdhService_TransmitClient
+	sendLock sync.Mutex
+	Stream   proto.PdhService_TransmitClient
 }
 
 func (c *ClientStreamWrapper) Send(msg *proto.Message) error {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
 	return c.Stream.Send(msg)
 }
 